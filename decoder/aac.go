@@ -0,0 +1,156 @@
+/*
+ *  (c) 2012 Bart Lauret
+ *
+ *  This file is part of slimgo.
+ *
+ *  slimgo is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  slimgo is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with slimgo.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package decoder
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/skrashevich/go-aac/pkg/adts"
+	aac "github.com/skrashevich/go-aac/pkg/decoder"
+	"github.com/terual/slimgo/pcm"
+	"io"
+)
+
+func init() {
+	Register(Codec{Format: 'a', Names: []string{"aac"}, New: func(r io.Reader, _ pcm.Format) (Decoder, error) {
+		return NewAAC(r)
+	}})
+}
+
+// adtsMaxSync is how far the start of the first ADTS frame is searched for
+const adtsMaxSync = 64 * 1024
+
+// AAC decodes an AAC-LC stream, either ADTS or in a MP4 container.
+type AAC struct {
+	decoder *aac.Decoder
+	adts    *bufio.Reader // nil for a MP4 stream
+	mp4     *mp4Reader
+	format  pcm.Format
+	frameBuffer
+}
+
+// NewAAC reads the headers of the AAC stream in r and decodes the first
+// frame.
+func NewAAC(r io.Reader) (*AAC, error) {
+	br := bufio.NewReaderSize(r, 64*1024)
+	d := &AAC{decoder: aac.New()}
+
+	h, err := br.Peek(8)
+	if err != nil {
+		return nil, err
+	}
+	if isMP4(h) {
+		if d.mp4, err = newMP4Reader(br); err != nil {
+			return nil, err
+		}
+		if d.mp4.track.codec != "mp4a" {
+			return nil, fmt.Errorf("decoder: MP4 stream holds %s, not AAC", d.mp4.track.codec)
+		}
+		if err = d.decoder.SetASC(d.mp4.track.config); err != nil {
+			return nil, err
+		}
+	} else {
+		if err = syncADTS(br); err != nil {
+			return nil, err
+		}
+		d.adts = br
+	}
+
+	// The format is known once the first frame is decoded
+	if d.buf, err = d.decode(nil); err != nil {
+		return nil, err
+	}
+	d.format = pcm.Format{
+		SampleFormat: pcm.S16LE,
+		Rate:         d.decoder.Config.SampleRate,
+		Channels:     len(d.decoder.Data),
+	}
+	return d, nil
+}
+
+// isMP4 reports whether h is the start of a MP4 file
+func isMP4(h []byte) bool {
+	typ := string(h[4:8])
+	return typ == "ftyp" || typ == "moov" || typ == "free" || typ == "skip" || typ == "wide"
+}
+
+// syncADTS skips to the first ADTS frame
+func syncADTS(r *bufio.Reader) error {
+	for i := 0; i < adtsMaxSync; i++ {
+		h, err := r.Peek(2)
+		if err != nil {
+			return err
+		}
+		if h[0] == 0xff && h[1]&0xf6 == 0xf0 {
+			return nil
+		}
+		r.Discard(1)
+	}
+	return errors.New("decoder: no ADTS frame found")
+}
+
+// frame returns the next AAC frame
+func (d *AAC) frame() ([]byte, error) {
+	if d.mp4 != nil {
+		return d.mp4.next()
+	}
+
+	h, err := d.adts.Peek(7)
+	if err != nil {
+		// io.EOF also after a partial header at the end of the stream
+		return nil, err
+	}
+	header, err := adts.ReadHeaderFromBytes(h)
+	if err != nil {
+		return nil, err
+	}
+	if header.FrameLength < 7 {
+		return nil, errors.New("decoder: bad ADTS frame length")
+	}
+	frame := make([]byte, header.FrameLength)
+	if _, err = io.ReadFull(d.adts, frame); err == io.ErrUnexpectedEOF {
+		// The last frame is cut off
+		return nil, io.EOF
+	}
+	return frame, err
+}
+
+// decode appends the next frame to dst
+func (d *AAC) decode(dst []byte) ([]byte, error) {
+	frame, err := d.frame()
+	if err != nil {
+		return dst, err
+	}
+	samples, err := d.decoder.DecodeFrame(frame)
+	if err != nil {
+		return dst, err
+	}
+	return putFloats(dst, samples), nil
+}
+
+// Format returns the format of the decoded audio.
+func (d *AAC) Format() pcm.Format {
+	return d.format
+}
+
+// Read reads decoded audio, whole frames at a time.
+func (d *AAC) Read(p []byte) (n int, err error) {
+	return d.read(p, d.decode, d.Format)
+}
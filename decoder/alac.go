@@ -0,0 +1,114 @@
+/*
+ *  (c) 2012 Bart Lauret
+ *
+ *  This file is part of slimgo.
+ *
+ *  slimgo is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  slimgo is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with slimgo.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package decoder
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/llehouerou/alac"
+	"github.com/terual/slimgo/pcm"
+	"io"
+)
+
+func init() {
+	Register(Codec{Format: 'l', Names: []string{"alc"}, New: func(r io.Reader, _ pcm.Format) (Decoder, error) {
+		return NewALAC(r)
+	}})
+}
+
+// ALAC decodes an Apple Lossless stream in a MP4 container.
+type ALAC struct {
+	mp4     *mp4Reader
+	decoder *alac.Alac
+	format  pcm.Format
+	frameBuffer
+}
+
+// NewALAC reads the headers of the MP4 stream in r.
+func NewALAC(r io.Reader) (*ALAC, error) {
+	mp4, err := newMP4Reader(bufio.NewReaderSize(r, 64*1024))
+	if err != nil {
+		return nil, err
+	}
+	if mp4.track.codec != "alac" {
+		return nil, fmt.Errorf("decoder: MP4 stream holds %s, not ALAC", mp4.track.codec)
+	}
+
+	// The magic cookie, sometimes preceded by a frma and alac atom
+	cookie := mp4.track.config
+	if len(cookie) >= 36 && string(cookie[4:8]) == "frma" {
+		cookie = cookie[24:]
+	}
+	if len(cookie) < 24 {
+		return nil, errors.New("decoder: short ALAC magic cookie")
+	}
+	config := alac.Config{
+		FrameSize:   int(binary.BigEndian.Uint32(cookie[0:])),
+		SampleSize:  int(cookie[5]),
+		NumChannels: int(cookie[9]),
+		SampleRate:  int(binary.BigEndian.Uint32(cookie[20:])),
+	}
+
+	d := &ALAC{mp4: mp4}
+	switch config.SampleSize {
+	case 16:
+		d.format.SampleFormat = pcm.S16LE
+	case 24:
+		d.format.SampleFormat = pcm.S24_3LE
+	default:
+		return nil, fmt.Errorf("decoder: %d bits ALAC is not supported", config.SampleSize)
+	}
+	if config.NumChannels < 1 || config.NumChannels > 2 {
+		return nil, fmt.Errorf("decoder: ALAC with %d channels is not supported", config.NumChannels)
+	}
+	d.format.Rate = config.SampleRate
+	d.format.Channels = config.NumChannels
+
+	if d.decoder, err = alac.NewWithConfig(config); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Format returns the format of the decoded audio.
+func (d *ALAC) Format() pcm.Format {
+	return d.format
+}
+
+// decode returns the next sample, a corrupt sample is returned as an error.
+// The decoder has its own buffer, so dst is not used.
+func (d *ALAC) decode(dst []byte) (buf []byte, err error) {
+	sample, err := d.mp4.next()
+	if err != nil {
+		return dst, err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			buf, err = dst, fmt.Errorf("decoder: corrupt ALAC frame: %v", r)
+		}
+	}()
+	return d.decoder.Decode(sample), nil
+}
+
+// Read reads decoded audio, whole frames at a time.
+func (d *ALAC) Read(p []byte) (n int, err error) {
+	return d.read(p, d.decode, d.Format)
+}
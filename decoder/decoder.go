@@ -0,0 +1,164 @@
+/*
+ *  (c) 2012 Bart Lauret
+ *
+ *  This file is part of slimgo.
+ *
+ *  slimgo is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  slimgo is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with slimgo.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package decoder decodes the compressed streams sent by the server to PCM,
+// so that the server does not have to transcode them. Every codec registers
+// itself by the format byte of the strm message.
+package decoder
+
+import (
+	"fmt"
+	"github.com/terual/slimgo/pcm"
+	"io"
+	"sync"
+)
+
+// Decoder decodes a stream to PCM.
+type Decoder interface {
+	// Read reads decoded audio. The codecs of compressed formats read
+	// whole frames, a PCM stream may end a Read in a frame. It returns
+	// io.EOF at the end of the stream, any other error means the stream
+	// cannot be decoded.
+	Read(p []byte) (n int, err error)
+
+	// Format returns the format of the audio returned by the last Read.
+	// It may change in a chained stream, but never within a Read.
+	Format() pcm.Format
+}
+
+// Codec opens decoders for a format of the server.
+type Codec struct {
+	Format byte     // format byte of strm, e.g. 'f'
+	Names  []string // names in the capabilities of HELO, e.g. flc
+
+	// New reads the header of the stream in r and returns its decoder.
+	// format is the PCM format given by the server, if any.
+	New func(r io.Reader, format pcm.Format) (Decoder, error)
+}
+
+var (
+	codecsMu sync.Mutex
+	codecs   []Codec
+)
+
+// Register adds a codec, replacing a codec with the same format byte. The
+// server prefers the codecs registered first.
+func Register(codec Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+
+	for i, c := range codecs {
+		if c.Format == codec.Format {
+			codecs[i] = codec
+			return
+		}
+	}
+	codecs = append(codecs, codec)
+}
+
+// Lookup returns the codec of a format byte.
+func Lookup(format byte) (codec Codec, ok bool) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+
+	for _, c := range codecs {
+		if c.Format == format {
+			return c, true
+		}
+	}
+	return codec, false
+}
+
+// Names returns the names of the registered codecs for HELO.
+func Names() (names []string) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+
+	for _, c := range codecs {
+		names = append(names, c.Names...)
+	}
+	return names
+}
+
+// frameBuffer holds the audio of the last decoded frame of a codec until it
+// is read.
+type frameBuffer struct {
+	buf []byte
+	off int
+}
+
+// read copies whole frames to p, calling decode to refill the buffer when it
+// is empty. decode appends to dst and may return nothing, e.g. for a header.
+func (b *frameBuffer) read(p []byte, decode func(dst []byte) ([]byte, error), format func() pcm.Format) (n int, err error) {
+	for b.off == len(b.buf) {
+		if b.buf, err = decode(b.buf[:0]); err != nil {
+			b.buf = b.buf[:0]
+			return 0, err
+		}
+		b.off = 0
+	}
+
+	frameSize := format().FrameSize()
+	if frameSize == 0 {
+		return 0, fmt.Errorf("decoder: decoded audio without frames, format %v", format())
+	}
+	n = copy(p[:len(p)/frameSize*frameSize], b.buf[b.off:])
+	b.off += n
+	return n, nil
+}
+
+// sampleFormat returns the little-endian sample format which holds samples
+// of bits, and the shift to scale them to it
+func sampleFormat(bits int) (format pcm.SampleFormat, shift uint) {
+	switch {
+	case bits <= 8:
+		return pcm.S8, uint(8 - bits)
+	case bits <= 16:
+		return pcm.S16LE, uint(16 - bits)
+	case bits <= 24:
+		return pcm.S24_3LE, uint(24 - bits)
+	}
+	return pcm.S32LE, uint(32 - bits)
+}
+
+// interleave appends the samples of every channel to dst, a frame at a time
+func interleave(dst []byte, format pcm.SampleFormat, shift uint, channels [][]int32) []byte {
+	if len(channels) == 0 {
+		return dst
+	}
+	size := format.Size()
+	n := len(channels[0])
+	start := len(dst)
+	end := start + n*size*len(channels)
+	if cap(dst) < end {
+		grown := make([]byte, start, end)
+		copy(grown, dst)
+		dst = grown
+	}
+	dst = dst[:end]
+
+	i := start
+	for j := 0; j < n; j++ {
+		for _, samples := range channels {
+			pcm.PutSample(dst[i:i+size], format, samples[j]<<shift)
+			i += size
+		}
+	}
+	return dst
+}
@@ -0,0 +1,390 @@
+/*
+ *  (c) 2012 Bart Lauret
+ *
+ *  This file is part of slimgo.
+ *
+ *  slimgo is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  slimgo is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with slimgo.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package decoder
+
+//go:generate go run testdata/generate.go
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/terual/slimgo/pcm"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// readFixture returns the contents of a file in testdata
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// decodeAll reads d to the end with reads of odd sizes. It checks that the
+// format is valid and that every Read returns whole frames.
+func decodeAll(t *testing.T, d Decoder, wholeFrames bool) []byte {
+	t.Helper()
+	var out []byte
+	buf := make([]byte, 4099)
+	for {
+		n, err := d.Read(buf)
+		format := d.Format()
+		if !format.Valid() {
+			t.Fatalf("invalid format %v", format)
+		}
+		if wholeFrames && n%format.FrameSize() != 0 {
+			t.Fatalf("read %d bytes, not whole frames of %v", n, format)
+		}
+		out = append(out, buf[:n]...)
+		if err == io.EOF {
+			return out
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	s16 := pcm.Format{SampleFormat: pcm.S16LE, Rate: 44100, Channels: 2}
+	tests := []struct {
+		file   string
+		codec  byte
+		server pcm.Format // format sent by the server
+		format pcm.Format
+		want   string
+	}{
+		{"s16le.raw", 'p', s16, s16, "s16le.raw"},
+		{"s16.wav", 'p', pcm.Format{}, s16, "s16le.raw"},
+		{"u8.wav", 'p', pcm.Format{}, pcm.Format{SampleFormat: pcm.S8, Rate: 8000, Channels: 1}, "s8.raw"},
+		{"s24.wav", 'p', s16, pcm.Format{SampleFormat: pcm.S24_3LE, Rate: 96000, Channels: 2}, "s24le.raw"},
+		{"s24.aiff", 'p', pcm.Format{}, pcm.Format{SampleFormat: pcm.S24_3BE, Rate: 48000, Channels: 2}, "s24be.raw"},
+		{"s16.aifc", 'p', pcm.Format{}, pcm.Format{SampleFormat: pcm.S16LE, Rate: 48000, Channels: 2}, "s16le.raw"},
+		{"s16.flac", 'f', pcm.Format{}, s16, "s16le.raw"},
+		{"s24.flac", 'f', pcm.Format{}, pcm.Format{SampleFormat: pcm.S24_3LE, Rate: 44100, Channels: 2}, "s24le.raw"},
+		{"s16.m4a", 'l', pcm.Format{}, s16, "s16le.raw"},
+		{"s24.m4a", 'l', pcm.Format{}, pcm.Format{SampleFormat: pcm.S24_3LE, Rate: 44100, Channels: 2}, "s24le.raw"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			codec, ok := Lookup(tt.codec)
+			if !ok {
+				t.Fatalf("no codec %q", tt.codec)
+			}
+			d, err := codec.New(bytes.NewReader(readFixture(t, tt.file)), tt.server)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if format := d.Format(); format != tt.format {
+				t.Errorf("format %v, want %v", format, tt.format)
+			}
+			got := decodeAll(t, d, tt.codec != 'p')
+			if want := readFixture(t, tt.want); !bytes.Equal(got, want) {
+				t.Errorf("decoded %d bytes, want the %d bytes of %s", len(got), len(want), tt.want)
+			}
+		})
+	}
+}
+
+func TestFrameBufferWithoutFormat(t *testing.T) {
+	var b frameBuffer
+	decode := func(dst []byte) ([]byte, error) { return append(dst, 0, 0, 0, 0), nil }
+	if _, err := b.read(make([]byte, 16), decode, func() pcm.Format { return pcm.Format{} }); err == nil {
+		t.Error("read without a frame size")
+	}
+}
+
+func TestPCMWithoutFormat(t *testing.T) {
+	if _, err := NewPCM(bytes.NewReader(readFixture(t, "s16le.raw")), pcm.Format{}); err == nil {
+		t.Error("PCM without header and format decoded")
+	}
+}
+
+func TestPCMHeaderTooLarge(t *testing.T) {
+	tests := map[string][]byte{
+		"WAV fmt":   []byte("RIFF\xff\xff\xff\xffWAVEfmt \xff\xff\xff\xff"),
+		"AIFF COMM": []byte("FORM\xff\xff\xff\xffAIFFCOMM\x7f\xff\xff\xff"),
+	}
+	for name, header := range tests {
+		t.Run(name, func(t *testing.T) {
+			data := append(header, make([]byte, 1024)...)
+			if _, err := NewPCM(bytes.NewReader(data), pcm.Format{}); err == nil {
+				t.Error("oversized header chunk read")
+			}
+		})
+	}
+}
+
+func TestAAC(t *testing.T) {
+	for _, file := range []string{"silence.aac", "silence.m4a"} {
+		t.Run(file, func(t *testing.T) {
+			d, err := NewAAC(bytes.NewReader(readFixture(t, file)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := pcm.Format{SampleFormat: pcm.S16LE, Rate: 44100, Channels: 1}
+			if format := d.Format(); format != want {
+				t.Errorf("format %v, want %v", format, want)
+			}
+			got := decodeAll(t, d, true)
+			if !bytes.Equal(got, make([]byte, 10*1024*2)) {
+				t.Errorf("decoded %d bytes, want %d bytes of silence", len(got), 10*1024*2)
+			}
+		})
+	}
+}
+
+// TestAACTruncated checks that a stream which is cut off ends at the last
+// whole frame, while an error of the stream is returned
+func TestAACTruncated(t *testing.T) {
+	// 4 bytes of junk and frames of 11 bytes
+	aac := readFixture(t, "silence.aac")
+	errStream := errors.New("stream failed")
+	tests := map[string]struct {
+		r   io.Reader
+		err error
+	}{
+		"in a frame":  {bytes.NewReader(aac[:len(aac)-2]), nil},
+		"in a header": {bytes.NewReader(aac[:len(aac)-8]), nil},
+		"error":       {io.MultiReader(bytes.NewReader(aac[:4+5*11+3]), iotest.ErrReader(errStream)), errStream},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			d, err := NewAAC(tt.r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err = io.ReadAll(d); err != tt.err {
+				t.Errorf("error %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestMP4Corrupt(t *testing.T) {
+	m4a := readFixture(t, "s16.m4a")
+	tests := map[string][]byte{
+		"moov to the end": append([]byte{0, 0, 0, 0, 'm', 'o', 'o', 'v'}, make([]byte, 20)...),
+		"huge moov":       {0xff, 0xff, 0xff, 0xff, 'm', 'o', 'o', 'v'},
+		"mdat first":      {0, 0, 0, 8, 'm', 'd', 'a', 't'},
+	}
+	// A sample count far beyond the size of the stsz atom
+	i := bytes.Index(m4a, []byte("stsz"))
+	huge := bytes.Clone(m4a)
+	binary.BigEndian.PutUint32(huge[i+12:], 0xffffffff)
+	tests["huge stsz"] = huge
+
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			d, err := NewALAC(bytes.NewReader(data))
+			if err == nil {
+				_, err = io.ReadAll(d)
+			}
+			if err == nil || err == io.EOF {
+				t.Errorf("decoded without error")
+			}
+		})
+	}
+}
+
+// lameFrame returns an Info frame with a LAME tag, for a stream of frames
+// like the first frame of mp3
+func lameFrame(t *testing.T, mp3 []byte, frames, delay, padding int) []byte {
+	t.Helper()
+	header, ok := parseMP3Header(mp3)
+	if !ok || header.mpeg1 || !header.mono || mp3[2]&2 != 0 {
+		t.Fatal("expected a MPEG 2 mono frame without padding")
+	}
+	frame := make([]byte, header.size)
+	copy(frame, mp3[:4])
+	tag := frame[4+9:]
+	copy(tag, "Info")
+	binary.BigEndian.PutUint32(tag[4:], 1) // number of frames only
+	binary.BigEndian.PutUint32(tag[8:], uint32(frames))
+	copy(tag[12:], "LAME3.100")
+	tag[12+21] = byte(delay >> 4)
+	tag[12+22] = byte(delay<<4 | padding>>8)
+	tag[12+23] = byte(padding)
+	return frame
+}
+
+func TestMP3Gapless(t *testing.T) {
+	mp3 := readFixture(t, "speech.mp3")
+	d, err := NewMP3(bytes.NewReader(mp3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	all := decodeAll(t, d, true)
+	frameSize := d.Format().FrameSize()
+
+	// Without a tag all is played, with a tag the delays and padding are
+	// removed. An ID3v2 tag before it is skipped.
+	const frames, delay, padding = 40, 576, 600
+	id3 := []byte{'I', 'D', '3', 4, 0, 0, 0, 0, 0, 5, 1, 2, 3, 4, 5}
+	tagged := bytes.Join([][]byte{id3, lameFrame(t, mp3, frames, delay, padding), mp3}, nil)
+	if d, err = NewMP3(bytes.NewReader(tagged)); err != nil {
+		t.Fatal(err)
+	}
+	got := decodeAll(t, d, true)
+
+	start := (delay + mp3DecoderDelay) * frameSize
+	end := start + (frames*576-delay-padding)*frameSize
+	if end > len(all) {
+		t.Fatalf("decoded %d bytes without tag, want at least %d", len(all), end)
+	}
+	if !bytes.Equal(got, all[start:end]) {
+		t.Errorf("decoded %d bytes, want bytes %d to %d of the untagged stream", len(got), start, end)
+	}
+}
+
+func TestOggChain(t *testing.T) {
+	files := []string{"vorbis.ogg", "speech.opus"}
+	var chain []io.Reader
+	var wants [][]byte
+	var formats []pcm.Format
+	for _, file := range files {
+		data := readFixture(t, file)
+		d, err := NewOgg(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		wants = append(wants, decodeAll(t, d, true))
+		formats = append(formats, d.Format())
+		chain = append(chain, bytes.NewReader(data))
+	}
+	if formats[0] == formats[1] {
+		t.Fatal("fixtures of the same format")
+	}
+
+	// Every Read returns audio of one stream, in the format of that stream
+	d, err := NewOgg(io.MultiReader(chain...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make([][]byte, len(files))
+	buf := make([]byte, 4099)
+	for {
+		n, err := d.Read(buf)
+		if n > 0 {
+			i := 0
+			if d.Format() == formats[1] {
+				i = 1
+			}
+			if i == 0 && len(got[1]) > 0 {
+				t.Fatal("audio of the first stream after the second")
+			}
+			got[i] = append(got[i], buf[:n]...)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := range files {
+		if !bytes.Equal(got[i], wants[i]) {
+			t.Errorf("decoded %d bytes of %s, want %d", len(got[i]), files[i], len(wants[i]))
+		}
+	}
+}
+
+func TestParseExec(t *testing.T) {
+	tests := []struct {
+		spec   string
+		format byte
+		names  []string
+		err    string
+	}{
+		{spec: "w=wvunpack -q - -o -", format: 'w', names: []string{"w"}},
+		{spec: "w:wvp=wvunpack", format: 'w', names: []string{"wvp"}},
+		{spec: "d:44100:16:2=dec", format: 'd', names: []string{"d"}},
+		{spec: "d:dsf:176400:24:2=dec", format: 'd', names: []string{"dsf"}},
+		{spec: "w:wvp", err: "no command"},
+		{spec: "w=  ", err: "no command"},
+		{spec: "wv=dec", err: "single byte"},
+		{spec: "d:44100:x:2=dec", err: "bad PCM format"},
+		{spec: "d:44100:12:2=dec", err: "not supported"},
+		{spec: "d:a:b=dec", err: "cannot parse"},
+	}
+	for _, tt := range tests {
+		codec, err := ParseExec(tt.spec)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("ParseExec(%q): error %v, want %q", tt.spec, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseExec(%q): %v", tt.spec, err)
+			continue
+		}
+		if codec.Format != tt.format || strings.Join(codec.Names, ",") != strings.Join(tt.names, ",") {
+			t.Errorf("ParseExec(%q) = %q %v, want %q %v", tt.spec, codec.Format, codec.Names, tt.format, tt.names)
+		}
+	}
+}
+
+func TestExec(t *testing.T) {
+	want := readFixture(t, "s16le.raw")
+	s16 := pcm.Format{SampleFormat: pcm.S16LE, Rate: 44100, Channels: 2}
+
+	// The format of the spec, of a WAV header and of the server
+	for _, tt := range []struct{ spec, file string }{
+		{"x:44100:16:2=cat", "s16le.raw"},
+		{"x=cat", "s16.wav"},
+	} {
+		codec, err := ParseExec(tt.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d, err := codec.New(bytes.NewReader(readFixture(t, tt.file)), pcm.Format{})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.spec, err)
+		}
+		if d.Format() != s16 {
+			t.Errorf("%s: format %v, want %v", tt.spec, d.Format(), s16)
+		}
+		if got := decodeAll(t, d, false); !bytes.Equal(got, want) {
+			t.Errorf("%s: decoded %d bytes, want %d", tt.spec, len(got), len(want))
+		}
+		d.(io.Closer).Close()
+	}
+
+	// A failing command is an error, not the end of the stream
+	codec, err := ParseExec("x=false")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := codec.New(bytes.NewReader(want), s16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.(io.Closer).Close()
+	if _, err = io.ReadAll(d); err == nil {
+		t.Error("failing command read without error")
+	}
+}
@@ -0,0 +1,162 @@
+/*
+ *  (c) 2012 Bart Lauret
+ *
+ *  This file is part of slimgo.
+ *
+ *  slimgo is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  slimgo is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with slimgo.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package decoder
+
+import (
+	"errors"
+	"fmt"
+	"github.com/terual/slimgo/pcm"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ParseExec parses a codec which pipes the stream through an external
+// command, from "<format>[:<name>][:<rate>:<bits>:<channels>]=<command>",
+// e.g. "w:wvp=wvunpack -q - -o -". The command reads the stream on stdin
+// and writes PCM to stdout, in the format given, the format of the server or
+// the format of a WAV or AIFF header. The name is sent to the server in
+// HELO, it defaults to the format byte. The command is split on spaces and
+// not run by a shell.
+func ParseExec(spec string) (codec Codec, err error) {
+	i := strings.Index(spec, "=")
+	if i < 0 {
+		return codec, fmt.Errorf("decoder: no command in codec %q", spec)
+	}
+	args := strings.Fields(spec[i+1:])
+	if len(args) == 0 {
+		return codec, fmt.Errorf("decoder: no command in codec %q", spec)
+	}
+
+	fields := strings.Split(spec[:i], ":")
+	if len(fields[0]) != 1 {
+		return codec, fmt.Errorf("decoder: format of codec %q is not a single byte", spec)
+	}
+	codec.Format = fields[0][0]
+	codec.Names = []string{fields[0]}
+	fields = fields[1:]
+	if len(fields) == 1 || len(fields) == 4 {
+		codec.Names = []string{fields[0]}
+		fields = fields[1:]
+	}
+
+	var declared pcm.Format
+	switch len(fields) {
+	case 0:
+	case 3:
+		var n [3]int
+		for j, f := range fields {
+			if n[j], err = strconv.Atoi(f); err != nil || n[j] <= 0 {
+				return codec, fmt.Errorf("decoder: bad PCM format in codec %q", spec)
+			}
+		}
+		declared.Rate, declared.Channels = n[0], n[2]
+		if declared.SampleFormat, _ = sampleFormat(n[1]); n[1] != declared.SampleFormat.Size()*8 {
+			return codec, fmt.Errorf("decoder: %d bits in codec %q are not supported", n[1], spec)
+		}
+	default:
+		return codec, fmt.Errorf("decoder: cannot parse codec %q", spec)
+	}
+
+	codec.New = func(r io.Reader, format pcm.Format) (Decoder, error) {
+		if declared.Valid() {
+			format = declared
+		}
+		return NewExec(r, format, args[0], args[1:]...)
+	}
+	return codec, nil
+}
+
+// Exec decodes a stream with an external command.
+type Exec struct {
+	*PCM
+	cmd    *exec.Cmd
+	stdout io.ReadCloser
+	once   sync.Once
+	err    error
+}
+
+// NewExec starts the command name and writes the stream in r to its stdin.
+// Its stdout is read as PCM of format, unless it starts with a WAV or AIFF
+// header.
+func NewExec(r io.Reader, format pcm.Format, name string, arg ...string) (*Exec, error) {
+	d := &Exec{cmd: exec.Command(name, arg...)}
+	d.cmd.Stderr = os.Stderr
+
+	stdin, err := d.cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if d.stdout, err = d.cmd.StdoutPipe(); err != nil {
+		return nil, err
+	}
+	if err = d.cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	// Wait does not wait for this copy, it ends when the stream ends or
+	// when the command has exited and stdin is closed
+	go func() {
+		io.Copy(stdin, r)
+		stdin.Close()
+	}()
+
+	if d.PCM, err = NewPCM(d.stdout, format); err != nil {
+		d.Close()
+		return nil, fmt.Errorf("decoder: output of %s: %v", name, err)
+	}
+	return d, nil
+}
+
+// Read reads the audio written by the command, a Read may end in a frame.
+func (d *Exec) Read(p []byte) (n int, err error) {
+	n, err = d.PCM.Read(p)
+	if err == io.EOF {
+		// A failing command would look like the end of the stream
+		if werr := d.wait(); werr != nil {
+			err = fmt.Errorf("decoder: %s: %v", d.cmd.Path, werr)
+		}
+	}
+	return n, err
+}
+
+// wait waits for the command to exit, once
+func (d *Exec) wait() error {
+	d.once.Do(func() {
+		d.err = d.cmd.Wait()
+	})
+	return d.err
+}
+
+// Close stops the command.
+func (d *Exec) Close() error {
+	if d.cmd.ProcessState == nil {
+		d.cmd.Process.Kill()
+	}
+	err := d.wait()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		// Killed, or failed on a broken pipe
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,80 @@
+/*
+ *  (c) 2012 Bart Lauret
+ *
+ *  This file is part of slimgo.
+ *
+ *  slimgo is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  slimgo is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with slimgo.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package decoder
+
+import (
+	"github.com/mewkiz/flac"
+	"github.com/terual/slimgo/pcm"
+	"io"
+)
+
+func init() {
+	Register(Codec{Format: 'f', Names: []string{"flc"}, New: func(r io.Reader, _ pcm.Format) (Decoder, error) {
+		return NewFLAC(r)
+	}})
+}
+
+// FLAC decodes a FLAC stream.
+type FLAC struct {
+	stream *flac.Stream
+	format pcm.Format
+	shift  uint
+	frameBuffer
+}
+
+// NewFLAC reads the header of the FLAC stream in r.
+func NewFLAC(r io.Reader) (*FLAC, error) {
+	stream, err := flac.New(r)
+	if err != nil {
+		return nil, err
+	}
+	sampleFormat, shift := sampleFormat(int(stream.Info.BitsPerSample))
+	return &FLAC{
+		stream: stream,
+		format: pcm.Format{
+			SampleFormat: sampleFormat,
+			Rate:         int(stream.Info.SampleRate),
+			Channels:     int(stream.Info.NChannels),
+		},
+		shift: shift,
+	}, nil
+}
+
+// Format returns the format of the decoded audio.
+func (d *FLAC) Format() pcm.Format {
+	return d.format
+}
+
+// Read reads decoded audio, whole frames at a time.
+func (d *FLAC) Read(p []byte) (n int, err error) {
+	return d.read(p, d.decode, d.Format)
+}
+
+// decode appends the next frame to dst
+func (d *FLAC) decode(dst []byte) ([]byte, error) {
+	frame, err := d.stream.ParseNext()
+	if err != nil {
+		return dst, err
+	}
+	channels := make([][]int32, len(frame.Subframes))
+	for i, subframe := range frame.Subframes {
+		channels[i] = subframe.Samples
+	}
+	return interleave(dst, d.format.SampleFormat, d.shift, channels), nil
+}
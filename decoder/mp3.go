@@ -0,0 +1,264 @@
+/*
+ *  (c) 2012 Bart Lauret
+ *
+ *  This file is part of slimgo.
+ *
+ *  slimgo is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  slimgo is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with slimgo.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package decoder
+
+import (
+	"bufio"
+	"encoding/binary"
+	"github.com/hajimehoshi/go-mp3"
+	"github.com/terual/slimgo/pcm"
+	"io"
+)
+
+func init() {
+	Register(Codec{Format: 'm', Names: []string{"mp3"}, New: func(r io.Reader, _ pcm.Format) (Decoder, error) {
+		return NewMP3(r)
+	}})
+}
+
+// mp3DecoderDelay is the delay of the MP3 decoder in samples, which is
+// compensated together with the encoder delay of the LAME tag
+const mp3DecoderDelay = 529
+
+// MP3 decodes a MP3 stream. ID3v2 tags are skipped, and the encoder delay and
+// padding of a LAME tag are removed for gapless playback.
+type MP3 struct {
+	decoder *mp3.Decoder
+	format  pcm.Format
+	skip    int64 // frames to skip at the start
+	left    int64 // frames left to play, -1 when unknown
+}
+
+// NewMP3 reads the header of the MP3 stream in r.
+func NewMP3(r io.Reader) (*MP3, error) {
+	br := bufio.NewReaderSize(r, 64*1024)
+	if err := skipID3v2(br); err != nil {
+		return nil, err
+	}
+
+	d := &MP3{left: -1}
+	if info, err := readXing(br); err == nil && info.lame && info.frames > 0 {
+		d.skip = int64(info.delay + mp3DecoderDelay)
+		d.left = int64(info.frames*info.samplesPerFrame - info.delay - info.padding)
+		if d.left < 0 {
+			d.left = -1
+		}
+	}
+
+	decoder, err := mp3.NewDecoder(br)
+	if err != nil {
+		return nil, err
+	}
+	d.decoder = decoder
+
+	// go-mp3 always decodes to 16 bits stereo
+	d.format = pcm.Format{SampleFormat: pcm.S16LE, Rate: decoder.SampleRate(), Channels: 2}
+	return d, nil
+}
+
+// Format returns the format of the decoded audio.
+func (d *MP3) Format() pcm.Format {
+	return d.format
+}
+
+// Read reads decoded audio, whole frames at a time.
+func (d *MP3) Read(p []byte) (n int, err error) {
+	frameSize := d.format.FrameSize()
+	p = p[:len(p)/frameSize*frameSize]
+
+	for n == 0 && len(p) > 0 {
+		if d.left == 0 {
+			return 0, io.EOF
+		}
+		if d.left > 0 && int64(len(p)/frameSize) > d.left {
+			p = p[:d.left*int64(frameSize)]
+		}
+
+		n, err = io.ReadAtLeast(d.decoder, p, frameSize)
+		n = n / frameSize * frameSize
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+
+		// Drop the encoder and decoder delay
+		if d.skip > 0 {
+			skip := int64(n / frameSize)
+			if skip > d.skip {
+				skip = d.skip
+			}
+			d.skip -= skip
+			copy(p, p[skip*int64(frameSize):n])
+			n -= int(skip) * frameSize
+		}
+		if d.left > 0 {
+			d.left -= int64(n / frameSize)
+		}
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// skipID3v2 skips the ID3v2 tags at the start of r
+func skipID3v2(r *bufio.Reader) error {
+	for {
+		h, err := r.Peek(10)
+		if err != nil || string(h[:3]) != "ID3" {
+			return nil
+		}
+		size := int(h[6]&0x7f)<<21 | int(h[7]&0x7f)<<14 | int(h[8]&0x7f)<<7 | int(h[9]&0x7f)
+		size += 10
+		if h[5]&0x10 != 0 {
+			size += 10 // footer
+		}
+		if _, err := r.Discard(size); err != nil {
+			return err
+		}
+	}
+}
+
+// mp3Header is the header of a MPEG audio layer III frame
+type mp3Header struct {
+	mpeg1           bool
+	mono            bool
+	size            int // of the frame in bytes
+	samplesPerFrame int
+}
+
+var mp3Bitrates = [2][16]int{
+	{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},     // MPEG 2 and 2.5
+	{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0}, // MPEG 1
+}
+
+var mp3SampleRates = [4][3]int{
+	{11025, 12000, 8000},  // MPEG 2.5
+	{0, 0, 0},             // reserved
+	{22050, 24000, 16000}, // MPEG 2
+	{44100, 48000, 32000}, // MPEG 1
+}
+
+// parseMP3Header parses a frame header, ok is false if h is not a layer III
+// frame header
+func parseMP3Header(h []byte) (header mp3Header, ok bool) {
+	if len(h) < 4 || h[0] != 0xff || h[1]&0xe0 != 0xe0 {
+		return header, false
+	}
+	version := int(h[1]>>3) & 3
+	layer := int(h[1]>>1) & 3
+	bitrateIndex := int(h[2] >> 4)
+	rateIndex := int(h[2]>>2) & 3
+	padding := int(h[2]>>1) & 1
+	if version == 1 || layer != 1 || rateIndex == 3 {
+		return header, false
+	}
+
+	header.mpeg1 = version == 3
+	header.mono = h[3]>>6 == 3
+	bitrate := 0
+	if header.mpeg1 {
+		bitrate = mp3Bitrates[1][bitrateIndex]
+	} else {
+		bitrate = mp3Bitrates[0][bitrateIndex]
+	}
+	rate := mp3SampleRates[version][rateIndex]
+	if bitrate == 0 {
+		return header, false
+	}
+
+	if header.mpeg1 {
+		header.samplesPerFrame = 1152
+		header.size = 144*bitrate*1000/rate + padding
+	} else {
+		header.samplesPerFrame = 576
+		header.size = 72*bitrate*1000/rate + padding
+	}
+	return header, true
+}
+
+// xingInfo is the information of a Xing or Info frame and its LAME tag
+type xingInfo struct {
+	frames          int
+	samplesPerFrame int
+	lame            bool
+	delay, padding  int // encoder delay and padding in samples, from the LAME tag
+}
+
+// readXing reads the Xing or Info frame at the start of r, which holds the
+// number of frames of a VBR stream and the LAME tag. The frame is discarded,
+// as it is not audio. Other frames are left in r.
+func readXing(r *bufio.Reader) (info xingInfo, err error) {
+	h, err := r.Peek(4)
+	if err != nil {
+		return info, err
+	}
+	header, ok := parseMP3Header(h)
+	if !ok {
+		return info, io.ErrNoProgress
+	}
+	frame, err := r.Peek(header.size)
+	if err != nil {
+		return info, err
+	}
+
+	// The Xing tag follows the side information
+	sideInfo := 17
+	switch {
+	case header.mpeg1 && !header.mono:
+		sideInfo = 32
+	case !header.mpeg1 && header.mono:
+		sideInfo = 9
+	}
+	offset := 4 + sideInfo
+	if offset+8 > len(frame) {
+		return info, io.ErrNoProgress
+	}
+	tag := string(frame[offset : offset+4])
+	if tag != "Xing" && tag != "Info" {
+		return info, io.ErrNoProgress
+	}
+
+	flags := binary.BigEndian.Uint32(frame[offset+4:])
+	pos := offset + 8
+	info.samplesPerFrame = header.samplesPerFrame
+	if flags&1 != 0 && pos+4 <= len(frame) {
+		info.frames = int(binary.BigEndian.Uint32(frame[pos:]))
+		pos += 4
+	}
+	if flags&2 != 0 {
+		pos += 4 // bytes
+	}
+	if flags&4 != 0 {
+		pos += 100 // seek table
+	}
+	if flags&8 != 0 {
+		pos += 4 // quality
+	}
+
+	// The LAME tag holds the encoder delay and padding, 12 bits each
+	if pos+24 <= len(frame) && string(frame[pos:pos+4]) == "LAME" {
+		d := frame[pos+21:]
+		info.lame = true
+		info.delay = int(d[0])<<4 | int(d[1])>>4
+		info.padding = int(d[1]&0x0f)<<8 | int(d[2])
+	}
+
+	_, err = r.Discard(header.size)
+	return info, err
+}
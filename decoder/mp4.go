@@ -0,0 +1,438 @@
+/*
+ *  (c) 2012 Bart Lauret
+ *
+ *  This file is part of slimgo.
+ *
+ *  slimgo is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  slimgo is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with slimgo.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package decoder
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// mp4Chunk is a chunk of samples in the mdat atom
+type mp4Chunk struct {
+	offset  int64
+	samples int
+}
+
+// mp4Track is the audio track of a MP4 file, as far as needed to decode it
+// while it is streamed
+type mp4Track struct {
+	codec      string // sample entry type, mp4a or alac
+	config     []byte // AudioSpecificConfig or ALAC magic cookie
+	channels   int
+	sampleSize int
+	sampleRate int
+	samples    int      // number of samples
+	fixedSize  uint32   // size of every sample, 0 if they are in sizes
+	sizes      []uint32 // of every sample
+	chunks     []mp4Chunk
+}
+
+// Limits of the sizes read from the stream, well above those of real files
+const (
+	maxMoovSize   = 64 << 20
+	maxSampleSize = 16 << 20
+)
+
+// mp4Reader reads the samples of the audio track of a MP4 stream. The moov
+// atom has to come before the mdat atom, as in every file prepared for
+// streaming.
+type mp4Reader struct {
+	r      *bufio.Reader
+	pos    int64 // bytes read from the start of the stream
+	track  *mp4Track
+	chunk  int
+	left   int // samples left in the chunk
+	sample int
+}
+
+// newMP4Reader reads the atoms of r up to the first sample of the audio
+// track.
+func newMP4Reader(r *bufio.Reader) (*mp4Reader, error) {
+	m := &mp4Reader{r: r}
+	for m.track == nil {
+		typ, size, err := m.atomHeader()
+		if err != nil {
+			return nil, err
+		}
+		if size < 0 {
+			return nil, errors.New("decoder: no moov atom in MP4 stream")
+		}
+		switch typ {
+		case "moov":
+			if size > maxMoovSize {
+				return nil, errors.New("decoder: MP4 moov atom too large")
+			}
+			moov := make([]byte, size)
+			if err = m.read(moov); err != nil {
+				return nil, err
+			}
+			if m.track, err = parseMoov(moov); err != nil {
+				return nil, err
+			}
+		case "mdat":
+			return nil, errors.New("decoder: mdat before moov in MP4 stream")
+		default:
+			if err = m.skip(size); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(m.track.chunks) == 0 {
+		return nil, errors.New("decoder: MP4 track without samples")
+	}
+	m.left = m.track.chunks[0].samples
+	return m, nil
+}
+
+func (m *mp4Reader) read(b []byte) error {
+	n, err := io.ReadFull(m.r, b)
+	m.pos += int64(n)
+	return err
+}
+
+func (m *mp4Reader) skip(n int64) error {
+	for n > 0 {
+		d := n
+		if d > 1<<30 {
+			d = 1 << 30
+		}
+		skipped, err := m.r.Discard(int(d))
+		m.pos += int64(skipped)
+		n -= int64(skipped)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// atomHeader reads the header of an atom and returns its type and the size
+// of its contents, -1 if it runs to the end of the stream
+func (m *mp4Reader) atomHeader() (typ string, size int64, err error) {
+	h := make([]byte, 8)
+	if err = m.read(h); err != nil {
+		return "", 0, err
+	}
+	typ = string(h[4:8])
+	size = int64(binary.BigEndian.Uint32(h[:4]))
+	switch size {
+	case 0:
+		return typ, -1, nil
+	case 1:
+		if err = m.read(h); err != nil {
+			return "", 0, err
+		}
+		size = int64(binary.BigEndian.Uint64(h)) - 16
+	default:
+		size -= 8
+	}
+	if size < 0 {
+		return "", 0, errors.New("decoder: bad MP4 atom size")
+	}
+	return typ, size, nil
+}
+
+// next returns the next sample of the track
+func (m *mp4Reader) next() ([]byte, error) {
+	track := m.track
+	for m.left == 0 {
+		m.chunk++
+		if m.chunk >= len(track.chunks) {
+			return nil, io.EOF
+		}
+		m.left = track.chunks[m.chunk].samples
+	}
+	if m.sample >= track.samples {
+		return nil, io.EOF
+	}
+
+	// Skip to the start of the chunk
+	if m.left == track.chunks[m.chunk].samples {
+		gap := track.chunks[m.chunk].offset - m.pos
+		if gap < 0 {
+			return nil, errors.New("decoder: MP4 chunks out of order")
+		}
+		if err := m.skip(gap); err != nil {
+			return nil, err
+		}
+	}
+
+	size := track.fixedSize
+	if size == 0 {
+		size = track.sizes[m.sample]
+	}
+	if size > maxSampleSize {
+		return nil, errors.New("decoder: MP4 sample too large")
+	}
+	sample := make([]byte, size)
+	if err := m.read(sample); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+		return nil, err
+	}
+	m.sample++
+	m.left--
+	return sample, nil
+}
+
+// mp4Atoms calls f for every atom in data
+func mp4Atoms(data []byte, f func(typ string, body []byte) error) error {
+	for len(data) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(data))
+		typ := string(data[4:8])
+		header := uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return errors.New("decoder: short MP4 atom")
+			}
+			size = binary.BigEndian.Uint64(data[8:])
+			header = 16
+		}
+		if size < header || size > uint64(len(data)) {
+			return errors.New("decoder: bad MP4 atom size")
+		}
+		if err := f(typ, data[header:size]); err != nil {
+			return err
+		}
+		data = data[size:]
+	}
+	return nil
+}
+
+// parseMoov returns the first audio track in moov which can be decoded
+func parseMoov(moov []byte) (*mp4Track, error) {
+	var found *mp4Track
+	err := mp4Atoms(moov, func(typ string, body []byte) error {
+		if typ != "trak" || found != nil {
+			return nil
+		}
+		track := new(mp4Track)
+		if err := parseContainer(track, body); err != nil {
+			return err
+		}
+		if track.codec != "" {
+			found = track
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, errors.New("decoder: no AAC or ALAC track in MP4 stream")
+	}
+	return found, nil
+}
+
+// parseContainer parses the atoms of a trak, down to the sample table
+func parseContainer(track *mp4Track, data []byte) error {
+	var stsc []byte
+	var offsets []int64
+	err := mp4Atoms(data, func(typ string, body []byte) error {
+		switch typ {
+		case "mdia", "minf", "stbl":
+			return parseContainer(track, body)
+		case "stsd":
+			return parseStsd(track, body)
+		case "stsz":
+			return parseStsz(track, body)
+		case "stsc":
+			stsc = body
+		case "stco", "co64":
+			offsets = parseChunkOffsets(typ, body)
+		}
+		return nil
+	})
+	if err != nil || offsets == nil {
+		return err
+	}
+	track.chunks = parseStsc(stsc, offsets)
+	return nil
+}
+
+// parseStsd parses the sample description of an AAC or ALAC track
+func parseStsd(track *mp4Track, body []byte) error {
+	// version, flags and entry count, then the first sample entry
+	if len(body) < 8+8+28 {
+		return nil
+	}
+	entry := body[8:]
+	size := int(binary.BigEndian.Uint32(entry))
+	typ := string(entry[4:8])
+	if typ != "mp4a" && typ != "alac" || size > len(entry) || size < 36 {
+		return nil
+	}
+	entry = entry[8:size]
+
+	// The audio sample entry, version 1 and 2 have more fields
+	version := binary.BigEndian.Uint16(entry[8:])
+	track.channels = int(binary.BigEndian.Uint16(entry[16:]))
+	track.sampleSize = int(binary.BigEndian.Uint16(entry[18:]))
+	track.sampleRate = int(binary.BigEndian.Uint32(entry[24:]) >> 16)
+	children := entry[28:]
+	switch {
+	case version == 1 && len(children) >= 16:
+		children = children[16:]
+	case version == 2 && len(children) >= 36:
+		children = children[36:]
+	}
+
+	return mp4Atoms(children, func(child string, body []byte) error {
+		switch {
+		case typ == "mp4a" && child == "esds" && len(body) > 4:
+			track.config = parseEsds(body[4:])
+		case typ == "alac" && child == "alac" && len(body) > 4:
+			track.config = body[4:]
+		default:
+			return nil
+		}
+		if track.config != nil {
+			track.codec = typ
+		}
+		return nil
+	})
+}
+
+// parseEsds returns the AudioSpecificConfig in the descriptors of an esds
+// atom
+func parseEsds(data []byte) []byte {
+	for len(data) > 2 {
+		tag := data[0]
+		length := 0
+		i := 1
+		for ; i < len(data) && i <= 4; i++ {
+			length = length<<7 | int(data[i]&0x7f)
+			if data[i]&0x80 == 0 {
+				i++
+				break
+			}
+		}
+		if i+length > len(data) {
+			return nil
+		}
+		body := data[i : i+length]
+
+		switch tag {
+		case 0x03: // ES_Descriptor, its descriptors follow some fields
+			if len(body) < 3 {
+				return nil
+			}
+			flags := body[2]
+			skip := 3
+			if flags&0x80 != 0 {
+				skip += 2
+			}
+			if flags&0x40 != 0 && len(body) > skip {
+				skip += 1 + int(body[skip])
+			}
+			if flags&0x20 != 0 {
+				skip += 2
+			}
+			if skip > len(body) {
+				return nil
+			}
+			data = body[skip:]
+		case 0x04: // DecoderConfigDescriptor, same
+			if len(body) < 13 {
+				return nil
+			}
+			data = body[13:]
+		case 0x05: // DecoderSpecificInfo
+			return body
+		default:
+			data = data[i+length:]
+		}
+	}
+	return nil
+}
+
+// parseStsz parses the sample sizes
+func parseStsz(track *mp4Track, body []byte) error {
+	if len(body) < 12 {
+		return errors.New("decoder: short stsz atom")
+	}
+	track.fixedSize = binary.BigEndian.Uint32(body[4:])
+	track.samples = int(binary.BigEndian.Uint32(body[8:]))
+	if track.fixedSize != 0 {
+		return nil
+	}
+	if track.samples > (len(body)-12)/4 {
+		return errors.New("decoder: short stsz atom")
+	}
+	track.sizes = make([]uint32, track.samples)
+	for i := range track.sizes {
+		track.sizes[i] = binary.BigEndian.Uint32(body[12+4*i:])
+	}
+	return nil
+}
+
+// parseChunkOffsets parses a stco or co64 atom
+func parseChunkOffsets(typ string, body []byte) []int64 {
+	if len(body) < 8 {
+		return nil
+	}
+	entrySize := 4
+	if typ == "co64" {
+		entrySize = 8
+	}
+	count := int(binary.BigEndian.Uint32(body[4:]))
+	if count > (len(body)-8)/entrySize {
+		count = (len(body) - 8) / entrySize
+	}
+	offsets := make([]int64, count)
+	for i := range offsets {
+		if typ == "co64" {
+			offsets[i] = int64(binary.BigEndian.Uint64(body[8+8*i:]))
+		} else {
+			offsets[i] = int64(binary.BigEndian.Uint32(body[8+4*i:]))
+		}
+	}
+	return offsets
+}
+
+// parseStsc returns the chunks with the number of samples in each
+func parseStsc(body []byte, offsets []int64) []mp4Chunk {
+	chunks := make([]mp4Chunk, len(offsets))
+	for i := range chunks {
+		chunks[i].offset = offsets[i]
+	}
+	if len(body) < 8 {
+		return chunks
+	}
+
+	// Every entry holds for the chunks up to the first chunk of the next
+	count := int(binary.BigEndian.Uint32(body[4:]))
+	for i := 0; i < count && 8+12*i+12 <= len(body); i++ {
+		first := int(binary.BigEndian.Uint32(body[8+12*i:])) - 1
+		samples := int(binary.BigEndian.Uint32(body[8+12*i+4:]))
+		if first < 0 {
+			first = 0
+		}
+		for c := first; c < len(chunks); c++ {
+			chunks[c].samples = samples
+		}
+	}
+	return chunks
+}
@@ -0,0 +1,239 @@
+/*
+ *  (c) 2012 Bart Lauret
+ *
+ *  This file is part of slimgo.
+ *
+ *  slimgo is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  slimgo is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with slimgo.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package decoder
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"github.com/terual/slimgo/pcm"
+	"io"
+)
+
+// Ogg Vorbis and Ogg Opus have their own format byte, but both are sniffed
+func init() {
+	newOgg := func(r io.Reader, _ pcm.Format) (Decoder, error) {
+		return NewOgg(r)
+	}
+	Register(Codec{Format: 'o', Names: []string{"ogg"}, New: newOgg})
+	Register(Codec{Format: 'u', Names: []string{"ops"}, New: newOgg})
+}
+
+// ErrOggCodec is returned for an Ogg stream which is not Vorbis or Opus.
+var ErrOggCodec = errors.New("decoder: unsupported codec in Ogg stream")
+
+// oggPacket is a packet of a logical bitstream
+type oggPacket struct {
+	data   []byte
+	serial uint32
+	bos    bool // first packet of the logical bitstream
+}
+
+// oggReader reads the packets of an Ogg stream
+type oggReader struct {
+	r        *bufio.Reader
+	serial   uint32
+	bos      bool
+	segments []byte // lacing values left of the current page
+	partial  []byte // packet continued on the next page
+}
+
+func newOggReader(r io.Reader) *oggReader {
+	return &oggReader{r: bufio.NewReaderSize(r, 64*1024)}
+}
+
+// readPage reads the header of the next page
+func (o *oggReader) readPage() error {
+	h := make([]byte, 27)
+	if _, err := io.ReadFull(o.r, h); err != nil {
+		return err
+	}
+	if string(h[:4]) != "OggS" {
+		return errors.New("decoder: lost Ogg page sync")
+	}
+	headerType := h[5]
+	serial := binary.LittleEndian.Uint32(h[14:18])
+
+	o.segments = make([]byte, h[26])
+	if _, err := io.ReadFull(o.r, o.segments); err != nil {
+		return err
+	}
+
+	// A packet is not continued across logical bitstreams
+	if serial != o.serial || headerType&0x01 == 0 {
+		o.partial = o.partial[:0]
+	}
+	o.serial = serial
+	o.bos = headerType&0x02 != 0
+	return nil
+}
+
+// next returns the next packet
+func (o *oggReader) next() (packet oggPacket, err error) {
+	for {
+		for len(o.segments) == 0 {
+			if err = o.readPage(); err != nil {
+				if err == io.ErrUnexpectedEOF {
+					err = io.EOF
+				}
+				return packet, err
+			}
+		}
+
+		// A packet ends with a segment shorter than 255 bytes
+		var size int
+		var i int
+		complete := false
+		for i < len(o.segments) {
+			size += int(o.segments[i])
+			i++
+			if o.segments[i-1] < 255 {
+				complete = true
+				break
+			}
+		}
+		o.segments = o.segments[i:]
+
+		start := len(o.partial)
+		o.partial = append(o.partial, make([]byte, size)...)
+		if _, err = io.ReadFull(o.r, o.partial[start:]); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				err = io.EOF
+			}
+			return packet, err
+		}
+
+		if complete {
+			packet = oggPacket{
+				data:   append([]byte(nil), o.partial...),
+				serial: o.serial,
+				bos:    o.bos,
+			}
+			o.partial = o.partial[:0]
+			o.bos = false
+			return packet, nil
+		}
+	}
+}
+
+// oggCodec decodes the packets of a logical bitstream
+type oggCodec interface {
+	// header reads a header packet and reports whether more are needed
+	header(packet []byte) (more bool, err error)
+
+	// decode appends the decoded audio of packet to dst
+	decode(dst []byte, packet []byte) ([]byte, error)
+
+	format() pcm.Format
+}
+
+// newOggCodec returns the codec of the first packet of a logical bitstream
+func newOggCodec(packet []byte) (oggCodec, error) {
+	switch {
+	case len(packet) >= 7 && string(packet[1:7]) == "vorbis":
+		return new(oggVorbis), nil
+	case len(packet) >= 8 && string(packet[:8]) == "OpusHead":
+		return new(oggOpus), nil
+	}
+	return nil, ErrOggCodec
+}
+
+// Ogg decodes an Ogg Vorbis or Ogg Opus stream. Chained streams, as sent by
+// radio stations which start a new logical bitstream on every song, are
+// decoded one after the other; the format may change between them.
+type Ogg struct {
+	pages  *oggReader
+	serial uint32
+	codec  oggCodec
+	frameBuffer
+}
+
+// NewOgg reads the headers of the first logical bitstream in r.
+func NewOgg(r io.Reader) (*Ogg, error) {
+	d := &Ogg{pages: newOggReader(r)}
+	packet, err := d.pages.next()
+	if err != nil {
+		return nil, err
+	}
+	if err = d.start(packet); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// start starts decoding the logical bitstream of the first packet
+func (d *Ogg) start(packet oggPacket) error {
+	codec, err := newOggCodec(packet.data)
+	if err != nil {
+		return err
+	}
+	more, err := codec.header(packet.data)
+	for err == nil && more {
+		if packet, err = d.pages.next(); err != nil {
+			break
+		}
+		more, err = codec.header(packet.data)
+	}
+	if err != nil {
+		return err
+	}
+	d.codec = codec
+	d.serial = packet.serial
+	return nil
+}
+
+// Format returns the format of the audio returned by the last Read, which
+// changes when a chained stream changes format.
+func (d *Ogg) Format() pcm.Format {
+	return d.codec.format()
+}
+
+// Read reads decoded audio, whole frames at a time. A Read never returns
+// audio of two logical bitstreams.
+func (d *Ogg) Read(p []byte) (n int, err error) {
+	return d.read(p, d.decode, d.Format)
+}
+
+// decode appends the audio of the next packet to dst
+func (d *Ogg) decode(dst []byte) ([]byte, error) {
+	packet, err := d.pages.next()
+	if err != nil {
+		return dst, err
+	}
+
+	// A new logical bitstream starts with its headers
+	if packet.bos || packet.serial != d.serial {
+		return dst, d.start(packet)
+	}
+	return d.codec.decode(dst, packet.data)
+}
+
+// putFloats appends the interleaved float samples to dst as 16 bits samples
+func putFloats(dst []byte, samples []float32) []byte {
+	for _, f := range samples {
+		s := int32(f * 32768)
+		if s > 32767 {
+			s = 32767
+		} else if s < -32768 {
+			s = -32768
+		}
+		dst = append(dst, byte(s), byte(s>>8))
+	}
+	return dst
+}
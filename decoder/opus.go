@@ -0,0 +1,92 @@
+/*
+ *  (c) 2012 Bart Lauret
+ *
+ *  This file is part of slimgo.
+ *
+ *  slimgo is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  slimgo is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with slimgo.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package decoder
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/jj11hh/opus"
+	"github.com/terual/slimgo/pcm"
+)
+
+// Opus is always decoded at 48kHz, a packet holds at most 120ms
+const (
+	opusRate         = 48000
+	opusMaxFrameSize = opusRate * 120 / 1000
+)
+
+// oggOpus decodes an Opus logical bitstream
+type oggOpus struct {
+	decoder  *opus.Decoder
+	channels int
+	preSkip  int // samples to drop at the start
+	headers  int
+	samples  []int16
+}
+
+func (o *oggOpus) header(packet []byte) (more bool, err error) {
+	o.headers++
+	if o.headers == 2 {
+		// OpusTags
+		return false, nil
+	}
+
+	// OpusHead
+	if len(packet) < 19 {
+		return false, errors.New("decoder: short OpusHead")
+	}
+	o.channels = int(packet[9])
+	o.preSkip = int(binary.LittleEndian.Uint16(packet[10:12]))
+	if mapping := packet[18]; mapping != 0 || o.channels > 2 {
+		return false, errors.New("decoder: only mono and stereo Opus are supported")
+	}
+	o.decoder, err = opus.NewDecoder(opusRate, o.channels)
+	if err != nil {
+		return false, err
+	}
+	o.samples = make([]int16, opusMaxFrameSize*o.channels)
+	return true, nil
+}
+
+func (o *oggOpus) decode(dst []byte, packet []byte) ([]byte, error) {
+	n, err := o.decoder.Decode(packet, o.samples)
+	if err != nil {
+		return dst, err
+	}
+	samples := o.samples[:n*o.channels]
+
+	// Drop the pre-skip of the encoder
+	if o.preSkip > 0 {
+		skip := o.preSkip
+		if skip > n {
+			skip = n
+		}
+		o.preSkip -= skip
+		samples = samples[skip*o.channels:]
+	}
+
+	for _, s := range samples {
+		dst = append(dst, byte(s), byte(s>>8))
+	}
+	return dst, nil
+}
+
+func (o *oggOpus) format() pcm.Format {
+	return pcm.Format{SampleFormat: pcm.S16LE, Rate: opusRate, Channels: o.channels}
+}
@@ -0,0 +1,248 @@
+/*
+ *  (c) 2012 Bart Lauret
+ *
+ *  This file is part of slimgo.
+ *
+ *  slimgo is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  slimgo is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with slimgo.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package decoder
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/terual/slimgo/pcm"
+	"io"
+	"math"
+)
+
+func init() {
+	Register(Codec{Format: 'p', Names: []string{"pcm"}, New: func(r io.Reader, format pcm.Format) (Decoder, error) {
+		return NewPCM(r, format)
+	}})
+}
+
+// maxChunkSize is the largest fmt or COMM chunk that is read
+const maxChunkSize = 64 * 1024
+
+// PCM reads a PCM stream. The format is taken from a WAV or AIFF header at
+// the start of the stream, or else from the server.
+type PCM struct {
+	r        io.Reader
+	format   pcm.Format
+	unsigned bool // 8 bits WAV samples are unsigned
+}
+
+// NewPCM reads the WAV or AIFF header of the stream in r, if there is one.
+// Without a header format is used, which has to be complete.
+func NewPCM(r io.Reader, format pcm.Format) (*PCM, error) {
+	br := bufio.NewReaderSize(r, 64*1024)
+	d := &PCM{r: br, format: format}
+
+	h, err := br.Peek(12)
+	switch {
+	case err == nil && string(h[:4]) == "RIFF" && string(h[8:12]) == "WAVE":
+		br.Discard(12)
+		err = d.readWAV(br)
+	case err == nil && string(h[:4]) == "FORM" && (string(h[8:12]) == "AIFF" || string(h[8:12]) == "AIFC"):
+		br.Discard(12)
+		err = d.readAIFF(br, string(h[8:12]) == "AIFC")
+	case !format.Valid():
+		err = fmt.Errorf("decoder: PCM stream without header and format %v", format)
+	default:
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// chunk reads the header of a RIFF or IFF chunk
+func chunk(r io.Reader, order binary.ByteOrder) (id string, size uint32, err error) {
+	h := make([]byte, 8)
+	if _, err = io.ReadFull(r, h); err != nil {
+		return "", 0, err
+	}
+	return string(h[:4]), order.Uint32(h[4:]), nil
+}
+
+// skipChunk skips the contents of a chunk, which are padded to an even size
+func skipChunk(r *bufio.Reader, size uint32) error {
+	_, err := r.Discard(int(size + size&1))
+	return err
+}
+
+// readWAV reads the chunks of a WAV file up to the audio
+func (d *PCM) readWAV(r *bufio.Reader) error {
+	haveFmt := false
+	for {
+		id, size, err := chunk(r, binary.LittleEndian)
+		if err != nil {
+			return err
+		}
+		switch id {
+		case "fmt ":
+			if size < 16 {
+				return errors.New("decoder: short WAV fmt chunk")
+			}
+			if size > maxChunkSize {
+				return fmt.Errorf("decoder: WAV fmt chunk of %d bytes", size)
+			}
+			f := make([]byte, size+size&1)
+			if _, err = io.ReadFull(r, f); err != nil {
+				return err
+			}
+			tag := binary.LittleEndian.Uint16(f[0:])
+			if tag == 0xfffe && size >= 26 {
+				// WAVE_FORMAT_EXTENSIBLE, the sub format starts with the tag
+				tag = binary.LittleEndian.Uint16(f[24:])
+			}
+			if tag != 1 {
+				return fmt.Errorf("decoder: WAV format %#x is not supported", tag)
+			}
+			d.format.Channels = int(binary.LittleEndian.Uint16(f[2:]))
+			d.format.Rate = int(binary.LittleEndian.Uint32(f[4:]))
+			bits := int(binary.LittleEndian.Uint16(f[14:]))
+			switch bits {
+			case 8:
+				d.format.SampleFormat = pcm.S8
+				d.unsigned = true
+			case 16:
+				d.format.SampleFormat = pcm.S16LE
+			case 24:
+				d.format.SampleFormat = pcm.S24_3LE
+			case 32:
+				d.format.SampleFormat = pcm.S32LE
+			default:
+				return fmt.Errorf("decoder: %d bits WAV is not supported", bits)
+			}
+			haveFmt = true
+		case "data":
+			if !haveFmt {
+				return errors.New("decoder: WAV data before fmt chunk")
+			}
+			// A streamed WAV often has no size, otherwise the chunks
+			// after the audio are not played
+			if size != 0 && size != 0xffffffff {
+				d.r = io.LimitReader(r, int64(size))
+			}
+			return nil
+		default:
+			if err = skipChunk(r, size); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// readAIFF reads the chunks of an AIFF or AIFF-C file up to the audio
+func (d *PCM) readAIFF(r *bufio.Reader, aifc bool) error {
+	haveComm := false
+	littleEndian := false
+	for {
+		id, size, err := chunk(r, binary.BigEndian)
+		if err != nil {
+			return err
+		}
+		switch id {
+		case "COMM":
+			if size < 18 || aifc && size < 22 {
+				return errors.New("decoder: short AIFF COMM chunk")
+			}
+			if size > maxChunkSize {
+				return fmt.Errorf("decoder: AIFF COMM chunk of %d bytes", size)
+			}
+			c := make([]byte, size+size&1)
+			if _, err = io.ReadFull(r, c); err != nil {
+				return err
+			}
+			d.format.Channels = int(binary.BigEndian.Uint16(c[0:]))
+			bits := int(binary.BigEndian.Uint16(c[6:]))
+			d.format.Rate = int(extendedFloat(c[8:18]))
+			if aifc {
+				switch compression := string(c[18:22]); compression {
+				case "NONE", "twos":
+				case "sowt":
+					littleEndian = true
+				default:
+					return fmt.Errorf("decoder: AIFF-C compression %q is not supported", compression)
+				}
+			}
+			switch {
+			case bits <= 8:
+				d.format.SampleFormat = pcm.S8
+			case bits <= 16 && littleEndian:
+				d.format.SampleFormat = pcm.S16LE
+			case bits <= 16:
+				d.format.SampleFormat = pcm.S16BE
+			case bits <= 24 && littleEndian:
+				d.format.SampleFormat = pcm.S24_3LE
+			case bits <= 24:
+				d.format.SampleFormat = pcm.S24_3BE
+			case bits <= 32 && littleEndian:
+				d.format.SampleFormat = pcm.S32LE
+			case bits <= 32:
+				d.format.SampleFormat = pcm.S32BE
+			default:
+				return fmt.Errorf("decoder: %d bits AIFF is not supported", bits)
+			}
+			haveComm = true
+		case "SSND":
+			if !haveComm {
+				return errors.New("decoder: AIFF SSND before COMM chunk")
+			}
+			h := make([]byte, 8)
+			if _, err = io.ReadFull(r, h); err != nil {
+				return err
+			}
+			offset := binary.BigEndian.Uint32(h)
+			if _, err = r.Discard(int(offset)); err != nil {
+				return err
+			}
+			if size > 8+offset {
+				d.r = io.LimitReader(r, int64(size-8-offset))
+			}
+			return nil
+		default:
+			if err = skipChunk(r, size); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// extendedFloat converts the 80 bits extended float of an AIFF sample rate
+func extendedFloat(b []byte) float64 {
+	exponent := int(binary.BigEndian.Uint16(b)&0x7fff) - 16383
+	mantissa := binary.BigEndian.Uint64(b[2:])
+	return math.Ldexp(float64(mantissa), exponent-63)
+}
+
+// Format returns the format of the audio.
+func (d *PCM) Format() pcm.Format {
+	return d.format
+}
+
+// Read reads the audio as it is received, a Read may end in a frame.
+func (d *PCM) Read(p []byte) (n int, err error) {
+	n, err = d.r.Read(p)
+	if d.unsigned {
+		for i := range p[:n] {
+			p[i] ^= 0x80
+		}
+	}
+	return n, err
+}
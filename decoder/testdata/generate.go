@@ -0,0 +1,370 @@
+//go:build ignore
+
+/*
+ *  (c) 2012 Bart Lauret
+ *
+ *  This file is part of slimgo.
+ *
+ *  slimgo is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  slimgo is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with slimgo.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Generate writes the synthetic fixtures of the decoder tests, with the PCM
+// they decode to. Run it in the decoder directory with
+// go run testdata/generate.go
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/mewkiz/flac"
+	"github.com/mewkiz/flac/frame"
+	"github.com/mewkiz/flac/meta"
+	"log"
+	"os"
+)
+
+const frames = 2500 // frames of the tone, not a multiple of any block size
+
+// tone returns a sawtooth of bits, different for every channel
+func tone(channels, bits int) [][]int32 {
+	span := int64(1)<<(bits-1) - 1
+	samples := make([][]int32, channels)
+	for ch := range samples {
+		samples[ch] = make([]int32, frames)
+		for i := range samples[ch] {
+			samples[ch][i] = int32(int64(i)*int64(ch+1)*span/50%(2*span) - span)
+		}
+	}
+	return samples
+}
+
+// interleave returns the samples as PCM of size bytes
+func interleave(samples [][]int32, size int, bigEndian bool) []byte {
+	var b []byte
+	for i := range samples[0] {
+		for _, s := range samples {
+			for j := 0; j < size; j++ {
+				shift := 8 * j
+				if bigEndian {
+					shift = 8 * (size - 1 - j)
+				}
+				b = append(b, byte(s[i]>>shift))
+			}
+		}
+	}
+	return b
+}
+
+func write(name string, data []byte) {
+	if err := os.WriteFile("testdata/"+name, data, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// chunk returns a RIFF or IFF chunk of id around the data
+func chunk(order binary.ByteOrder, id string, data ...[]byte) []byte {
+	body := bytes.Join(data, nil)
+	b := make([]byte, 8, 8+len(body)+1)
+	copy(b, id)
+	order.PutUint32(b[4:], uint32(len(body)))
+	return append(b, body...)
+}
+
+// box returns a MP4 atom of typ around the data, its size includes the
+// header
+func box(typ string, data ...[]byte) []byte {
+	body := bytes.Join(data, nil)
+	b := make([]byte, 8, 8+len(body))
+	binary.BigEndian.PutUint32(b, uint32(8+len(body)))
+	copy(b[4:], typ)
+	return append(b, body...)
+}
+
+func u16(order binary.AppendByteOrder, v int) []byte {
+	return order.AppendUint16(nil, uint16(v))
+}
+
+func u32(order binary.AppendByteOrder, v int) []byte {
+	return order.AppendUint32(nil, uint32(v))
+}
+
+// wav returns a WAV file, with an odd sized chunk before the audio
+func wav(samples [][]int32, bits, rate int, extensible bool) []byte {
+	le := binary.LittleEndian
+	size := bits / 8
+	channels := len(samples)
+	data := interleave(samples, size, false)
+	if bits == 8 {
+		for i := range data {
+			data[i] ^= 0x80
+		}
+	}
+	fmtChunk := bytes.Join([][]byte{
+		u16(le, 1), u16(le, channels), u32(le, rate), u32(le, rate*channels*size),
+		u16(le, channels*size), u16(le, bits),
+	}, nil)
+	if extensible {
+		copy(fmtChunk, u16(le, 0xfffe))
+		fmtChunk = append(fmtChunk, u16(le, 22)...)
+		fmtChunk = append(fmtChunk, u16(le, bits)...)
+		fmtChunk = append(fmtChunk, u32(le, 3)...)
+		fmtChunk = append(fmtChunk, u16(le, 1)...)
+		fmtChunk = append(fmtChunk, make([]byte, 14)...)
+	}
+	list := append(chunk(le, "LIST", []byte("INFOtag")), 0)
+	riff := bytes.Join([][]byte{[]byte("WAVE"), chunk(le, "fmt ", fmtChunk), list, chunk(le, "data", data)}, nil)
+	return chunk(le, "RIFF", riff)
+}
+
+// aiff returns an AIFF file, or an AIFF-C file with little-endian samples
+func aiff(samples [][]int32, bits int, aifc bool) []byte {
+	be := binary.BigEndian
+	// 48000 Hz as 80 bits extended float
+	rate := []byte{0x40, 0x0e, 0xbb, 0x80, 0, 0, 0, 0, 0, 0}
+	comm := bytes.Join([][]byte{u16(be, len(samples)), u32(be, frames), u16(be, bits), rate}, nil)
+	form, data := "AIFF", interleave(samples, bits/8, true)
+	if aifc {
+		form, data = "AIFC", interleave(samples, bits/8, false)
+		comm = append(comm, "sowt"...)
+		comm = append(comm, 0, 0) // empty compression name
+	}
+	ssnd := append(make([]byte, 8), data...)
+	return chunk(be, "FORM", []byte(form), chunk(be, "COMM", comm), chunk(be, "SSND", ssnd))
+}
+
+// flacFile returns a FLAC file
+func flacFile(samples [][]int32, bits int) []byte {
+	const blockSize = 1024
+	var out bytes.Buffer
+	info := &meta.StreamInfo{
+		BlockSizeMin:  blockSize,
+		BlockSizeMax:  blockSize,
+		SampleRate:    44100,
+		NChannels:     uint8(len(samples)),
+		BitsPerSample: uint8(bits),
+		NSamples:      frames,
+	}
+	enc, err := flac.NewEncoder(&out, info)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for start := 0; start < frames; start += blockSize {
+		end := min(start+blockSize, frames)
+		f := &frame.Frame{Header: frame.Header{
+			HasFixedBlockSize: true,
+			BlockSize:         uint16(end - start),
+			SampleRate:        44100,
+			Channels:          frame.ChannelsLR,
+			BitsPerSample:     uint8(bits),
+		}}
+		for _, s := range samples {
+			f.Subframes = append(f.Subframes, &frame.Subframe{
+				SubHeader: frame.SubHeader{Pred: frame.PredVerbatim},
+				Samples:   s[start:end],
+				NSamples:  end - start,
+			})
+		}
+		if err = enc.WriteFrame(f); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err = enc.Close(); err != nil {
+		log.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+// bitWriter writes the bits of ALAC and AAC frames
+type bitWriter struct {
+	b []byte
+	n int // bits used in the last byte
+}
+
+func (w *bitWriter) write(v uint32, bits int) {
+	for i := bits - 1; i >= 0; i-- {
+		if w.n == 0 {
+			w.b = append(w.b, 0)
+		}
+		w.b[len(w.b)-1] |= byte(v>>i&1) << (7 - w.n)
+		w.n = (w.n + 1) % 8
+	}
+}
+
+// alacFrames returns uncompressed ALAC frames of stereo samples
+func alacFrames(samples [][]int32, bits, frameLength int) (out [][]byte) {
+	for start := 0; start < frames; start += frameLength {
+		end := min(start+frameLength, frames)
+		w := new(bitWriter)
+		w.write(1, 3)  // channel pair element
+		w.write(0, 16) // tag and unused
+		partial := uint32(0)
+		if end-start < frameLength {
+			partial = 1
+		}
+		w.write(partial, 1)
+		w.write(0, 2) // no uncompressed bytes
+		w.write(1, 1) // not compressed
+		if partial == 1 {
+			w.write(uint32(end-start), 32)
+		}
+		for i := start; i < end; i++ {
+			for _, s := range samples {
+				w.write(uint32(s[i])&(1<<bits-1), bits)
+			}
+		}
+		w.write(7, 3) // end element
+		out = append(out, w.b)
+	}
+	return out
+}
+
+// silentAAC returns an AAC-LC frame of silence: a single channel element
+// without scale factor bands
+func silentAAC() []byte {
+	w := new(bitWriter)
+	w.write(0, 3)   // single channel element
+	w.write(0, 4)   // tag
+	w.write(100, 8) // global gain
+	w.write(0, 4)   // ics info: long window
+	w.write(0, 6)   // max_sfb
+	w.write(0, 1)   // no prediction
+	w.write(0, 3)   // no pulse, tns or gain control data
+	w.write(7, 3)   // end element
+	return w.b
+}
+
+// adts returns an ADTS header of a mono 44.1kHz AAC-LC frame of size bytes
+func adts(size int) []byte {
+	w := new(bitWriter)
+	w.write(0xfff, 12)
+	w.write(1, 4) // MPEG-4, layer 0, no CRC
+	w.write(1, 2) // LC
+	w.write(4, 4) // 44.1kHz
+	w.write(0, 1)
+	w.write(1, 3) // mono
+	w.write(0, 4)
+	w.write(uint32(7+size), 13)
+	w.write(0x7ff, 11)
+	w.write(0, 2)
+	return w.b
+}
+
+// mp4 returns a MP4 file with an audio track of entry, holding the samples
+// in chunks of two samples
+func mp4(entry []byte, samples [][]byte) []byte {
+	be := binary.BigEndian
+	full := func(typ string, data ...[]byte) []byte {
+		return box(typ, append([][]byte{make([]byte, 4)}, data...)...)
+	}
+
+	sizes := [][]byte{u32(be, 0), u32(be, len(samples))}
+	for _, s := range samples {
+		sizes = append(sizes, u32(be, len(s)))
+	}
+	chunks := (len(samples) + 1) / 2
+	moov := func(mdat int) []byte {
+		offsets := [][]byte{u32(be, chunks)}
+		pos := mdat
+		for i, s := range samples {
+			if i%2 == 0 {
+				offsets = append(offsets, u32(be, pos))
+			}
+			pos += len(s)
+		}
+		stsc := [][]byte{u32(be, 1), u32(be, 1), u32(be, 2), u32(be, 1)}
+		if len(samples)%2 == 1 {
+			stsc[0] = u32(be, 2)
+			stsc = append(stsc, u32(be, chunks), u32(be, 1), u32(be, 1))
+		}
+		stbl := box("stbl",
+			full("stsd", u32(be, 1), entry),
+			full("stsc", stsc...),
+			full("stsz", sizes...),
+			full("stco", offsets...),
+		)
+		return box("moov", box("trak", box("mdia", box("minf", stbl))))
+	}
+
+	ftyp := box("ftyp", []byte("M4A \x00\x00\x00\x00M4A mp42"))
+	mdat := len(ftyp) + len(moov(0)) + 8
+	return bytes.Join([][]byte{ftyp, moov(mdat), box("mdat", samples...)}, nil)
+}
+
+// sampleEntry returns an audio sample entry of typ with the child atom
+func sampleEntry(typ string, channels, bits, rate int, child []byte) []byte {
+	be := binary.BigEndian
+	return box(typ,
+		make([]byte, 6), u16(be, 1), make([]byte, 8),
+		u16(be, channels), u16(be, bits), make([]byte, 4), u32(be, rate<<16),
+		child,
+	)
+}
+
+// alacFile returns a MP4 file with ALAC of stereo samples
+func alacFile(samples [][]int32, bits int) []byte {
+	be := binary.BigEndian
+	const frameLength = 1024
+	cookie := bytes.Join([][]byte{
+		u32(be, frameLength), {0, byte(bits), 40, 10, 14, 2}, u16(be, 255),
+		u32(be, 0), u32(be, 0), u32(be, 44100),
+	}, nil)
+	entry := sampleEntry("alac", 2, bits, 44100, box("alac", make([]byte, 4), cookie))
+	return mp4(entry, alacFrames(samples, bits, frameLength))
+}
+
+// aacFile returns a MP4 file with count silent AAC frames
+func aacFile(count int) []byte {
+	asc := []byte{0x12, 0x08} // AAC-LC, 44.1kHz, mono
+	dsi := append([]byte{0x05, byte(len(asc))}, asc...)
+	dcd := append([]byte{0x04, byte(13 + len(dsi)), 0x40, 0x15}, make([]byte, 11)...)
+	dcd = append(dcd, dsi...)
+	es := append([]byte{0x03, byte(3 + len(dcd)), 0, 1, 0}, dcd...)
+	entry := sampleEntry("mp4a", 1, 16, 44100, box("esds", make([]byte, 4), es))
+
+	samples := make([][]byte, count)
+	for i := range samples {
+		samples[i] = silentAAC()
+	}
+	return mp4(entry, samples)
+}
+
+func main() {
+	mono8 := tone(1, 8)
+	stereo16 := tone(2, 16)
+	stereo24 := tone(2, 24)
+
+	write("s8.raw", interleave(mono8, 1, false))
+	write("s16le.raw", interleave(stereo16, 2, false))
+	write("s24le.raw", interleave(stereo24, 3, false))
+	write("s24be.raw", interleave(stereo24, 3, true))
+
+	write("u8.wav", wav(mono8, 8, 8000, false))
+	write("s16.wav", wav(stereo16, 16, 44100, false))
+	write("s24.wav", wav(stereo24, 24, 96000, true))
+	write("s24.aiff", aiff(stereo24, 24, false))
+	write("s16.aifc", aiff(stereo16, 16, true))
+	write("s16.flac", flacFile(stereo16, 16))
+	write("s24.flac", flacFile(stereo24, 24))
+	write("s16.m4a", alacFile(stereo16, 16))
+	write("s24.m4a", alacFile(stereo24, 24))
+
+	var aac []byte
+	aac = append(aac, "junk"...)
+	for i := 0; i < 10; i++ {
+		frame := silentAAC()
+		aac = append(append(aac, adts(len(frame))...), frame...)
+	}
+	write("silence.aac", aac)
+	write("silence.m4a", aacFile(10))
+}
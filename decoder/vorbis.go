@@ -0,0 +1,48 @@
+/*
+ *  (c) 2012 Bart Lauret
+ *
+ *  This file is part of slimgo.
+ *
+ *  slimgo is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  slimgo is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with slimgo.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package decoder
+
+import (
+	"github.com/jfreymuth/vorbis"
+	"github.com/terual/slimgo/pcm"
+)
+
+// oggVorbis decodes a Vorbis logical bitstream
+type oggVorbis struct {
+	decoder vorbis.Decoder
+}
+
+func (v *oggVorbis) header(packet []byte) (more bool, err error) {
+	if err = v.decoder.ReadHeader(packet); err != nil {
+		return false, err
+	}
+	return !v.decoder.HeadersRead(), nil
+}
+
+func (v *oggVorbis) decode(dst []byte, packet []byte) ([]byte, error) {
+	samples, err := v.decoder.Decode(packet)
+	if err != nil {
+		return dst, err
+	}
+	return putFloats(dst, samples), nil
+}
+
+func (v *oggVorbis) format() pcm.Format {
+	return pcm.Format{SampleFormat: pcm.S16LE, Rate: v.decoder.SampleRate(), Channels: v.decoder.Channels()}
+}
@@ -0,0 +1,47 @@
+/*
+ *  (c) 2012 Bart Lauret
+ *
+ *  This file is part of slimgo.
+ *
+ *  slimgo is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  slimgo is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with slimgo.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"fmt"
+	"github.com/terual/slimgo/output"
+	"log"
+	"strings"
+)
+
+// List the ALSA output devices and the mixer controls of every card
+func printDevices() {
+	devices, err := output.Devices()
+	if err != nil {
+		log.Fatalf("Cannot list devices: %v", err)
+	}
+	fmt.Println("Output devices (-o):")
+	for _, device := range devices {
+		fmt.Printf("  %-30s %s\n", device.Name, device.Description)
+	}
+
+	cards, err := output.Cards()
+	if err != nil {
+		log.Fatalf("Cannot list mixers: %v", err)
+	}
+	fmt.Println("Mixer controls (-V):")
+	for _, card := range cards {
+		fmt.Printf("  %-6s %-24s %s\n", card.Device, card.Name, strings.Join(card.Mixers, ", "))
+	}
+}
@@ -0,0 +1,93 @@
+/*
+ *  (c) 2012 Bart Lauret
+ *
+ *  This file is part of slimgo.
+ *
+ *  slimgo is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  slimgo is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with slimgo.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/terual/slimgo/slimproto"
+	"log"
+	"net"
+	"time"
+)
+
+// discoveryTimeout is how long servers get to answer a discovery request
+const discoveryTimeout = 2 * time.Second
+
+// Servers found by discovery, which is done only once
+var discovered []slimproto.Server
+var discoveredErr error
+var discoveryDone bool
+
+// Discover the servers on the local network
+func discover() ([]slimproto.Server, error) {
+	if !discoveryDone {
+		discovered, discoveredErr = slimproto.Discover(discoveryTimeout)
+		discoveryDone = true
+
+		if *debug {
+			for _, server := range discovered {
+				log.Println("Discovered", server)
+			}
+		}
+	}
+	return discovered, discoveredErr
+}
+
+// resolveServer returns the address and port of the server given by spec,
+// which is an IP-address, or name:<name> or uuid:<uuid> of a discovered
+// server. When spec is empty, the first discovered server is used.
+func resolveServer(spec string, port int) (addr net.IP, serverPort int, err error) {
+	if ip := net.ParseIP(spec); ip != nil {
+		return ip, port, nil
+	}
+	if spec == "" && !*useDisco {
+		return nil, 0, errors.New("Please use server discovery or supply the server, see --help for more information.")
+	}
+
+	servers, err := discover()
+	if err != nil {
+		return nil, 0, fmt.Errorf("Discovery failed: %v", err)
+	}
+	server, err := slimproto.SelectServer(servers, spec)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%v, use slimgo -discover to list the servers or supply the IP-address", err)
+	}
+	if len(servers) > 1 && spec == "" {
+		log.Printf("Found %v servers, using %s. Use -S name:<name> to select another one.", len(servers), server.Name)
+	}
+
+	return server.Addr, server.Port, nil
+}
+
+// printServers prints the servers found by discovery
+func printServers() {
+	servers, err := discover()
+	if err != nil {
+		log.Fatalf("Discovery failed: %v", err)
+	}
+	if len(servers) == 0 {
+		fmt.Println("No servers found.")
+		return
+	}
+	for _, server := range servers {
+		fmt.Printf("%-20s %-15v json port %-5v version %-8s uuid %s\n",
+			server.Name, server.Addr, server.JSONPort, server.Version, server.UUID)
+	}
+}
@@ -0,0 +1,19 @@
+module github.com/terual/slimgo
+
+go 1.25.6
+
+require (
+	github.com/hajimehoshi/go-mp3 v0.3.4
+	github.com/jfreymuth/vorbis v1.0.2
+	github.com/jj11hh/opus v1.0.1
+	github.com/llehouerou/alac v0.1.0
+	github.com/mewkiz/flac v1.0.14
+	github.com/skrashevich/go-aac v0.1.0
+)
+
+require (
+	github.com/icza/bitio v1.1.0 // indirect
+	github.com/mewkiz/pkg v0.0.0-20250417130911-3f050ff8c56d // indirect
+	github.com/mewpkg/term v0.0.0-20241026122259-37a80af23985 // indirect
+	github.com/tetratelabs/wazero v1.9.0 // indirect
+)
@@ -18,123 +18,246 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
 	"errors"
 	"flag"
-	"github.com/terual/alsa-go"
+	"github.com/terual/slimgo/decoder"
+	"github.com/terual/slimgo/output"
+	"github.com/terual/slimgo/player"
 	"log"
 	"net"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
-	"time"
-	"syscall"
-	"os/signal"
+	"sync"
 )
 
-// startTime is used by jiffies()
-var startTime time.Time = time.Now()
-
 // Setup flags for command line options
 var useDisco = flag.Bool("F", true, "use discovery to find SB server")
-var lmsAddr = flag.String("S", "", "IP-address of the Logitech Media Server")
+var lmsAddr = flag.String("S", "", "Logitech Media Server to use: an IP-address, or name:<name> or uuid:<uuid> of a server found by discovery")
 var lmsPortr = flag.Int("P", 3483, "Port of the Logitech Media Server")
-var outputDevice = flag.String("o", "default", "ALSA output device, use aplay -L to see the options")
+var outputDevice = flag.String("o", "default", "Output: an ALSA device (see aplay -L), null, wav:<file> or raw:<file>, raw:- for stdout")
 var debug = flag.Bool("d", true, "view debug messages")
 var macAddr = flag.String("m", "00:00:00:00:00:02", "Sets the mac address for this instance. Use the colon-separated notation. The default is 00:00:00:00:00:02. Squeezebox Server uses this value to distinguish multiple instances, allowing per-player settings.")
-
-// slimaudio struct
-type audio struct {
-	Handle            *alsa.Handle
-	State             string
-	Pcmsamplesize     uint8
-	Pcmsamplerate     uint8
-	Pcmchannels       uint8
-	Pcmendian         uint8
-	FramesWritten     int
-	LastFramesWritten int
-	NewTrack          bool
+var mixerControl = flag.String("V", "", "ALSA mixer control to set the volume with instead of in software, e.g. PCM, or hw:1:PCM for another card")
+var mixerRange = flag.String("R", "", "dB range the volume is mapped to with -V, e.g. -60:0, defaults to the top 50dB of the mixer")
+var bufSizes = flag.String("b", "", "sizes in KB of the stream buffer and of the buffer of decoded audio, as <stream>,<output>, defaults to 2048,4096")
+var caFile = flag.String("cafile", "", "PEM file with the CA certificates to verify HTTPS streams with, instead of those of the system")
+var insecure = flag.Bool("insecure", false, "do not verify the certificates of HTTPS streams, e.g. for a local test server")
+var listDevices = flag.Bool("l", false, "list the output devices and mixer controls and exit")
+var listServers = flag.Bool("discover", false, "list the servers found by discovery and exit")
+var codecs codecFlags
+
+func init() {
+	flag.Var(&codecs, "codec", "decode a format with an external command, e.g. \"w:wvp=wvunpack -q - -o -\", as <format>[:<name>][:<rate>:<bits>:<channels>]=<command>. Can be repeated.")
 }
 
-var slimaudio audio
-
-// slimproto struct
-type proto struct {
-	Conn *net.TCPConn
-	Addr net.IP
-	Port int
+var configFile = flag.String("config", "", "JSON file with a list of players to start, each with a name, mac, device and optionally a mixer, mixerRange, server and port. Overrides -m, -o, -V and -R.")
+
+// playerConfig is a single player in the -config file
+type playerConfig struct {
+	Name   string `json:"name"`
+	MAC    string `json:"mac"`
+	Device string `json:"device"`
+	Mixer  string `json:"mixer"`
+	Range  string `json:"mixerRange"`
+	Server string `json:"server"`
+	Port   int    `json:"port"`
 }
 
-var slimproto proto
+// codecFlags are the -codec options
+type codecFlags []string
 
-//slimbuffer struct
-type buffer struct {
-	Reader *Reader
-	Init   bool
+func (c *codecFlags) String() string {
+	return strings.Join(*c, ", ")
 }
 
-var slimbuffer buffer
-
-// channel which blocks until slimproto is ready
-var slimprotoChannel = make(chan int) // Allocate a channel.
-var slimaudioChannel = make(chan int) // Allocate a channel.
+func (c *codecFlags) Set(spec string) error {
+	*c = append(*c, spec)
+	return nil
+}
 
 func main() {
 	// First parse the command line options
 	flag.Parse()
 
-	mac, err := macConvert(*macAddr)
+	streamBufSize, outputBufSize, err := parseBufSizes(*bufSizes)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	tlsConfig, err := newTLSConfig(*caFile, *insecure)
 	if err != nil {
-		log.Fatalf("Cannot parse MAC address: %v", *macAddr)
+		log.Fatalln(err)
 	}
 
-	// Use discovery for SB server
-	if *useDisco == true {
-		slimproto.Addr, slimproto.Port = slimprotoDisco()
-	} else if *lmsAddr != "" {
-		slimproto.Addr, slimproto.Port = net.ParseIP(*lmsAddr), 3483
-	} else {
-		log.Fatalln("Please use server discovery or supply the IP-address of the server, see --help for more information.")
+	for _, spec := range codecs {
+		codec, err := decoder.ParseExec(spec)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		decoder.Register(codec)
 	}
 
-	// TODO
-	slimbuffer.Reader = new(Reader)
-	slimbuffer.Reader.buf = make([]byte, 1048576)
-	slimbuffer.Init = false
+	if *listDevices {
+		printDevices()
+		return
+	}
 
-	// Open a ALSA handle
-	if *outputDevice == "default" {
-		log.Println("Using output device 'default', consider using 'hw:0,0' to avoid conversion in ALSA")
+	if *listServers {
+		printServers()
+		return
 	}
-	slimaudio.Handle = slimaudioOpen(*outputDevice)
-	defer slimaudioClose(slimaudio.Handle)
-	maxRate, _ := slimaudio.Handle.MaxSampleRate()
-	log.Printf("Maximum sample rate of %s: %v Hz.", *outputDevice, maxRate)
 
-	// This catches a SIGTERM et al. to be able to send a BYE! message
-	go signalWatcher()
+	entries := []playerConfig{{MAC: *macAddr, Device: *outputDevice, Mixer: *mixerControl, Range: *mixerRange, Server: *lmsAddr, Port: *lmsPortr}}
+	if *configFile != "" {
+		var err error
+		entries, err = readConfig(*configFile)
+		if err != nil {
+			log.Fatalf("Cannot read %s: %v", *configFile, err)
+		}
+	}
 
-	// Connect to SB server
-	go slimproto_main(slimproto.Addr, slimproto.Port, mac, maxRate)
+	var players []*player.Player
+	for _, entry := range entries {
+		config, err := entry.config()
+		if err != nil {
+			log.Fatalln(err)
+		}
+		config.Debug = *debug
+		config.StreamBufSize = streamBufSize
+		config.OutputBufSize = outputBufSize
+		config.TLS = tlsConfig
 
-	<-slimprotoChannel // Wait for slimproto to finish; discard sent value.
-}
+		p, err := player.New(config)
+		if err != nil {
+			log.Fatalf("Cannot start player: %v", err)
+		}
+		defer p.Close()
+		players = append(players, p)
+	}
+
+	// This catches a SIGINT to be able to send a BYE! message
+	ctx, cancel := context.WithCancel(context.Background())
+	go signalWatcher(cancel)
+
+	// Connect to SB server
+	var wg sync.WaitGroup
+	for _, p := range players {
+		wg.Add(1)
+		go func(p *player.Player) {
+			defer wg.Done()
+			if err := p.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+				log.Printf("Player stopped: %v", err)
+			}
+		}(p)
+	}
 
-// jiffies returns a 1kHz counter since start of program
-func jiffies() uint32 {
-	return uint32(time.Now().Sub(startTime))
+	wg.Wait() // Wait for the players to finish
 }
 
-// signalWatcher waits for a signal and sends a BYE! message on SIGINT (SIGTERM and SIGQUIT unimplemented)
-func signalWatcher() {
-	sig := make(chan os.Signal)
+// signalWatcher waits for SIGINT and stops the players, which send a BYE! message (SIGTERM and SIGQUIT unimplemented)
+func signalWatcher(cancel context.CancelFunc) {
+	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, os.Interrupt)
 
 	<-sig
 	log.Println("Caught SIGINT, shutting down...")
-	// First send a BYE! msg to the server
-	_ = slimprotoBye()
-	// Then end program
-	os.Exit(0)
+	cancel()
+}
+
+// readConfig reads the players from a -config file
+func readConfig(name string) (entries []playerConfig, err error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if err = json.NewDecoder(f).Decode(&entries); err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, errors.New("no players configured")
+	}
+	return entries, nil
+}
+
+// config returns the player.Config of entry, the server is resolved using
+// discovery if needed
+func (entry playerConfig) config() (config player.Config, err error) {
+	mac, err := macConvert(entry.MAC)
+	if err != nil {
+		return config, errors.New("Cannot parse MAC address: " + entry.MAC)
+	}
+
+	var mixerRange output.Range
+	if entry.Range != "" {
+		mixerRange, err = output.ParseRange(entry.Range)
+		if err != nil {
+			return config, err
+		}
+	}
+
+	addr, port, err := resolveServer(entry.Server, entry.Port)
+	if err != nil {
+		return config, err
+	}
+
+	return player.Config{
+		Name:       entry.Name,
+		MAC:        mac,
+		Device:     entry.Device,
+		Mixer:      entry.Mixer,
+		MixerRange: mixerRange,
+		Server:     addr,
+		Port:       port,
+	}, nil
+}
+
+// newTLSConfig returns the settings of HTTPS streams, nil for the defaults
+func newTLSConfig(caFile string, insecure bool) (*tls.Config, error) {
+	if caFile == "" && !insecure {
+		return nil, nil
+	}
+	config := &tls.Config{InsecureSkipVerify: insecure}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.New("No certificates found in " + caFile)
+		}
+	}
+	return config, nil
+}
+
+// parseBufSizes parses the -b option, a size left out or 0 is the default
+func parseBufSizes(s string) (stream, output int, err error) {
+	if s == "" {
+		return 0, 0, nil
+	}
+	sizes := strings.Split(s, ",")
+	if len(sizes) > 2 {
+		return 0, 0, errors.New("Cannot parse buffer sizes: " + s)
+	}
+	kb := make([]int, 2)
+	for i, size := range sizes {
+		if size == "" {
+			continue
+		}
+		kb[i], err = strconv.Atoi(size)
+		if err != nil || kb[i] < 0 {
+			return 0, 0, errors.New("Cannot parse buffer sizes: " + s)
+		}
+	}
+	return kb[0] * 1024, kb[1] * 1024, nil
 }
 
 // Convert a colon seperated mac-address to a uint8 array
@@ -173,55 +296,3 @@ func getMacAddr() (mac [6]uint8, err error) {
 	}
 	return
 }
-
-// Main loop
-func slimproto_main(addr net.IP, port int, mac [6]uint8, maxRate int) {
-
-	for {
-		var reconnect = false
-
-		if *debug {
-			log.Printf("Using %v:%v for slimproto\n", addr, port)
-		}
-
-		slimprotoConnect(addr, port)
-		defer slimprotoClose()
-
-		err := slimprotoHello(mac, maxRate)
-		if err != nil {
-			if *debug {
-				log.Println("Handshake failed, trying again")
-			}
-			time.Sleep(1e9)
-			continue
-		} else {
-			if *debug {
-				log.Println("HELO send succesfully")
-			}
-		}
-
-		for {
-
-			err := slimprotoRecv()
-			if err != nil {
-				switch err {
-				case syscall.EAGAIN:
-					log.Println("Slimproto timeout")
-					time.Sleep(1e9)
-					reconnect = true
-				default:
-					log.Println("Slimproto error", err)
-					time.Sleep(1e9)
-					reconnect = true
-				}
-			}
-			if reconnect {
-				break
-			}
-		}
-		slimaudio.Handle.Drop()
-		_ = slimbuffer.Reader.Flush()
-	}
-	slimprotoChannel <- 1 // Send a signal; value does not matter. 
-
-}
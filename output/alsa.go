@@ -0,0 +1,194 @@
+/*
+ *  (c) 2012 Bart Lauret
+ *
+ *  This file is part of slimgo.
+ *
+ *  slimgo is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  slimgo is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with slimgo.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package output
+
+/*
+#cgo LDFLAGS: -lasound
+#include <stdlib.h>
+#include <alsa/asoundlib.h>
+
+// latency of the device in us
+#define LATENCY 100000
+
+static int open_pcm(snd_pcm_t **pcm, const char *name) {
+	return snd_pcm_open(pcm, name, SND_PCM_STREAM_PLAYBACK, 0);
+}
+
+static int set_params(snd_pcm_t *pcm, snd_pcm_format_t format, unsigned int channels, unsigned int rate, snd_pcm_uframes_t *buffer_size) {
+	snd_pcm_uframes_t period_size;
+	int err = snd_pcm_set_params(pcm, format, SND_PCM_ACCESS_RW_INTERLEAVED, channels, rate, 1, LATENCY);
+	if (err < 0)
+		return err;
+	return snd_pcm_get_params(pcm, buffer_size, &period_size);
+}
+
+static int max_rate(snd_pcm_t *pcm, unsigned int *rate) {
+	snd_pcm_hw_params_t *params;
+	int dir = 0;
+	int err = snd_pcm_hw_params_malloc(&params);
+	if (err < 0)
+		return err;
+	if ((err = snd_pcm_hw_params_any(pcm, params)) >= 0)
+		err = snd_pcm_hw_params_get_rate_max(params, rate, &dir);
+	snd_pcm_hw_params_free(params);
+	return err;
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"github.com/terual/slimgo/pcm"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+var alsaFormats = map[pcm.SampleFormat]C.snd_pcm_format_t{
+	pcm.S8:      C.SND_PCM_FORMAT_S8,
+	pcm.S16LE:   C.SND_PCM_FORMAT_S16_LE,
+	pcm.S16BE:   C.SND_PCM_FORMAT_S16_BE,
+	pcm.S24_3LE: C.SND_PCM_FORMAT_S24_3LE,
+	pcm.S24_3BE: C.SND_PCM_FORMAT_S24_3BE,
+	pcm.S32LE:   C.SND_PCM_FORMAT_S32_LE,
+	pcm.S32BE:   C.SND_PCM_FORMAT_S32_BE,
+}
+
+// alsaError returns the message of an ALSA error code
+func alsaError(err C.int) error {
+	return errors.New(C.GoString(C.snd_strerror(err)))
+}
+
+// alsaOutput plays the audio on an ALSA device.
+type alsaOutput struct {
+	pcm        *C.snd_pcm_t
+	format     pcm.Format
+	bufferSize atomic.Int64 // in frames, read while the output plays
+}
+
+// NewALSA opens the ALSA device for playback.
+func NewALSA(device string) (Output, error) {
+	name := C.CString(device)
+	defer C.free(unsafe.Pointer(name))
+
+	o := new(alsaOutput)
+	if err := C.open_pcm(&o.pcm, name); err < 0 {
+		return nil, fmt.Errorf("alsa %s: %v", device, alsaError(err))
+	}
+	return o, nil
+}
+
+func (o *alsaOutput) Open(format pcm.Format) error {
+	if _, ok := alsaFormats[format.SampleFormat]; !ok || !format.Valid() {
+		return fmt.Errorf("output: invalid format %v", format)
+	}
+
+	_ = o.Drop()
+	o.format = pcm.Format{}
+	if err := o.setParams(format); err != nil {
+		return err
+	}
+	o.format = format
+	return nil
+}
+
+// Apply the hw parameters, this also drains the ALSA buffer
+func (o *alsaOutput) setParams(format pcm.Format) error {
+	var bufferSize C.snd_pcm_uframes_t
+	err := C.set_params(o.pcm, alsaFormats[format.SampleFormat],
+		C.uint(format.Channels), C.uint(format.Rate), &bufferSize)
+	if err < 0 {
+		return fmt.Errorf("alsa set parameters: %v", alsaError(err))
+	}
+	o.bufferSize.Store(int64(bufferSize))
+	return nil
+}
+
+func (o *alsaOutput) Write(data []byte) (n int, err error) {
+	frameSize := o.format.FrameSize()
+	if frameSize == 0 || len(data) < frameSize {
+		return 0, nil
+	}
+	frames := C.snd_pcm_writei(o.pcm, unsafe.Pointer(&data[0]), C.snd_pcm_uframes_t(len(data)/frameSize))
+	if frames < 0 {
+		// Prepare the device again, e.g. after an underrun, the caller
+		// decides whether to write the rest
+		C.snd_pcm_recover(o.pcm, C.int(frames), 1)
+		return 0, fmt.Errorf("alsa write: %v", alsaError(C.int(frames)))
+	}
+	return int(frames) * frameSize, nil
+}
+
+func (o *alsaOutput) Delay() (frames int, err error) {
+	var delay C.snd_pcm_sframes_t
+	if err := C.snd_pcm_delay(o.pcm, &delay); err < 0 {
+		return 0, fmt.Errorf("alsa delay: %v", alsaError(err))
+	}
+	return int(delay), nil
+}
+
+func (o *alsaOutput) BufferSize() int {
+	return int(o.bufferSize.Load())
+}
+
+func (o *alsaOutput) Pause() error {
+	if err := C.snd_pcm_pause(o.pcm, 1); err < 0 {
+		return fmt.Errorf("alsa pause: %v", alsaError(err))
+	}
+	return nil
+}
+
+func (o *alsaOutput) Unpause() error {
+	if err := C.snd_pcm_pause(o.pcm, 0); err < 0 {
+		return fmt.Errorf("alsa unpause: %v", alsaError(err))
+	}
+	return nil
+}
+
+func (o *alsaOutput) Drop() error {
+	if err := C.snd_pcm_drop(o.pcm); err < 0 {
+		return fmt.Errorf("alsa drop: %v", alsaError(err))
+	}
+	return nil
+}
+
+// Drain waits until the device has played every frame
+func (o *alsaOutput) Drain() error {
+	for {
+		delay, err := o.Delay()
+		if err != nil || delay <= 0 || o.format.Rate == 0 {
+			return err
+		}
+		time.Sleep(time.Duration(delay) * time.Second / time.Duration(o.format.Rate))
+	}
+}
+
+func (o *alsaOutput) MaxSampleRate() int {
+	var rate C.uint
+	if err := C.max_rate(o.pcm, &rate); err < 0 {
+		return 0
+	}
+	return int(rate)
+}
+
+func (o *alsaOutput) Close() error {
+	C.snd_pcm_close(o.pcm)
+	return nil
+}
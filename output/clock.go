@@ -0,0 +1,150 @@
+/*
+ *  (c) 2012 Bart Lauret
+ *
+ *  This file is part of slimgo.
+ *
+ *  slimgo is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  slimgo is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with slimgo.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package output
+
+import (
+	"sync"
+	"time"
+)
+
+// clockBufferFrames is the buffer of an output without hardware, in frames
+// at 44.1kHz, about half a second
+const clockBufferFrames = 22050
+
+// clock plays an output without hardware in real time, as if it were a
+// sound card, so that the elapsed time seen by the server is right.
+type clock struct {
+	mu       sync.Mutex
+	rate     int
+	start    time.Time // when the first frame started playing
+	frames   int64     // frames written since start
+	pausedAt time.Time
+}
+
+// reset starts the clock over at rate
+func (c *clock) reset(rate int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.rate = rate
+	c.frames = 0
+	c.start = time.Now()
+	c.pausedAt = time.Time{}
+}
+
+// played returns the frames played, the caller holds c.mu
+func (c *clock) played() int64 {
+	if c.rate == 0 {
+		return c.frames
+	}
+	now := time.Now()
+	if !c.pausedAt.IsZero() {
+		now = c.pausedAt
+	}
+	played := int64(now.Sub(c.start)) * int64(c.rate) / int64(time.Second)
+	if played > c.frames {
+		// Underrun, the output waits for new frames
+		c.start = now.Add(-time.Duration(c.frames * int64(time.Second) / int64(c.rate)))
+		played = c.frames
+	}
+	return played
+}
+
+// delay returns the frames written but not yet played
+func (c *clock) delay() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return int(c.frames - c.played())
+}
+
+// bufferSize returns the size of the buffer of the clock in frames
+func (c *clock) bufferSize() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return clockBufferFrames * c.rate / 44100
+}
+
+// write blocks until n frames fit in the buffer and adds them
+func (c *clock) write(n int) {
+	for {
+		c.mu.Lock()
+		size := int64(clockBufferFrames * c.rate / 44100)
+		wait := c.frames + int64(n) - c.played() - size
+		paused := !c.pausedAt.IsZero()
+		if wait <= 0 || c.rate == 0 {
+			c.frames += int64(n)
+			c.mu.Unlock()
+			return
+		}
+		c.mu.Unlock()
+
+		if paused {
+			time.Sleep(10 * time.Millisecond)
+		} else {
+			time.Sleep(time.Duration(wait * int64(time.Second) / int64(c.rate)))
+		}
+	}
+}
+
+// pause stops the clock
+func (c *clock) pause() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.pausedAt.IsZero() {
+		c.pausedAt = time.Now()
+	}
+}
+
+// unpause starts the clock where it was paused
+func (c *clock) unpause() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.pausedAt.IsZero() {
+		c.start = c.start.Add(time.Now().Sub(c.pausedAt))
+		c.pausedAt = time.Time{}
+	}
+}
+
+// drop discards the frames not yet played
+func (c *clock) drop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.frames = c.played()
+}
+
+// drain waits until every frame is played
+func (c *clock) drain() {
+	for {
+		c.mu.Lock()
+		left := c.frames - c.played()
+		paused := !c.pausedAt.IsZero()
+		rate := c.rate
+		c.mu.Unlock()
+
+		if left <= 0 || paused || rate == 0 {
+			return
+		}
+		time.Sleep(time.Duration(left * int64(time.Second) / int64(rate)))
+	}
+}
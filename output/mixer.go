@@ -0,0 +1,358 @@
+/*
+ *  (c) 2012 Bart Lauret
+ *
+ *  This file is part of slimgo.
+ *
+ *  slimgo is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  slimgo is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with slimgo.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package output
+
+/*
+#cgo LDFLAGS: -lasound
+#include <stdlib.h>
+#include <alsa/asoundlib.h>
+
+static int open_mixer(const char *card, snd_mixer_t **mixer) {
+	int err = snd_mixer_open(mixer, 0);
+	if (err < 0)
+		return err;
+	if ((err = snd_mixer_attach(*mixer, card)) < 0 ||
+	    (err = snd_mixer_selem_register(*mixer, NULL, NULL)) < 0 ||
+	    (err = snd_mixer_load(*mixer)) < 0) {
+		snd_mixer_close(*mixer);
+		*mixer = NULL;
+	}
+	return err;
+}
+
+static snd_mixer_elem_t *find_elem(snd_mixer_t *mixer, const char *name, unsigned int index) {
+	snd_mixer_selem_id_t *sid;
+	snd_mixer_elem_t *elem;
+	if (snd_mixer_selem_id_malloc(&sid) < 0)
+		return NULL;
+	snd_mixer_selem_id_set_index(sid, index);
+	snd_mixer_selem_id_set_name(sid, name);
+	elem = snd_mixer_find_selem(mixer, sid);
+	snd_mixer_selem_id_free(sid);
+	return elem;
+}
+
+static char *get_hint(void **hints, int i, const char *id) {
+	return snd_device_name_get_hint(hints[i], id);
+}
+
+static int count_hints(void **hints) {
+	int n = 0;
+	while (hints[n] != NULL)
+		n++;
+	return n;
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unsafe"
+)
+
+// lmsRangeDB is the range of the volume curve of the server, a volume of 1
+// is about -50dB
+const lmsRangeDB = 50.0
+
+// Range is a range of volume in dB.
+type Range struct {
+	Min, Max float64
+}
+
+// ParseRange parses a range of the form min:max, e.g. -60:0.
+func ParseRange(s string) (r Range, err error) {
+	i := strings.LastIndex(s, ":")
+	if i < 0 {
+		return r, errors.New("mixer range must be min:max, e.g. -60:0")
+	}
+	if r.Min, err = strconv.ParseFloat(s[:i], 64); err != nil {
+		return r, err
+	}
+	if r.Max, err = strconv.ParseFloat(s[i+1:], 64); err != nil {
+		return r, err
+	}
+	if r.Min >= r.Max {
+		return r, errors.New("mixer range must be min:max with min < max")
+	}
+	return r, nil
+}
+
+func (r Range) String() string {
+	return fmt.Sprintf("%vdB:%vdB", r.Min, r.Max)
+}
+
+// Mixer sets the volume with an ALSA simple mixer element, so that the audio
+// itself is left untouched.
+type Mixer struct {
+	mixer     *C.snd_mixer_t
+	elem      *C.snd_mixer_elem_t
+	name      string
+	hasDB     bool
+	min, max  C.long // the raw volume range of the element
+	rng       Range  // the range the volume of the server is mapped to
+	hasSwitch bool
+}
+
+// MixerCard returns the control device of the card of an ALSA device, e.g.
+// hw:1 for plughw:1,0.
+func MixerCard(device string) string {
+	for _, prefix := range []string{"hw:", "plughw:"} {
+		if strings.HasPrefix(device, prefix) {
+			card := device[len(prefix):]
+			if i := strings.Index(card, ","); i >= 0 {
+				card = card[:i]
+			}
+			return "hw:" + card
+		}
+	}
+	return "default"
+}
+
+// OpenMixer opens the mixer element control, e.g. PCM or Digital,1, of the
+// card of device. The card can also be given as hw:1:PCM. The volume of the
+// server is mapped to r, a zero Range maps it to the top of the range of the
+// element.
+func OpenMixer(control string, device string, r Range) (*Mixer, error) {
+	card := MixerCard(device)
+	if i := strings.LastIndex(control, ":"); i >= 0 {
+		card, control = control[:i], control[i+1:]
+	}
+	var index int
+	if i := strings.LastIndex(control, ","); i >= 0 {
+		n, err := strconv.Atoi(control[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("mixer %s: bad index", control)
+		}
+		control, index = control[:i], n
+	}
+
+	m := &Mixer{name: card + ":" + control}
+
+	ccard := C.CString(card)
+	defer C.free(unsafe.Pointer(ccard))
+	if err := C.open_mixer(ccard, &m.mixer); err < 0 {
+		return nil, fmt.Errorf("mixer %s: %v", card, alsaError(err))
+	}
+
+	cname := C.CString(control)
+	defer C.free(unsafe.Pointer(cname))
+	m.elem = C.find_elem(m.mixer, cname, C.uint(index))
+	if m.elem == nil || C.snd_mixer_selem_has_playback_volume(m.elem) == 0 {
+		m.Close()
+		return nil, fmt.Errorf("mixer %s: no playback volume control %s", card, control)
+	}
+
+	C.snd_mixer_selem_get_playback_volume_range(m.elem, &m.min, &m.max)
+	m.hasSwitch = C.snd_mixer_selem_has_playback_switch(m.elem) != 0
+
+	// Without dB information the range is mapped linearly to the raw range
+	var minDB, maxDB C.long
+	m.hasDB = C.snd_mixer_selem_get_playback_dB_range(m.elem, &minDB, &maxDB) == 0 && minDB < maxDB
+	if m.hasDB {
+		elemRange := Range{float64(minDB) / 100, float64(maxDB) / 100}
+		if r == (Range{}) {
+			r = Range{math.Max(elemRange.Min, elemRange.Max-lmsRangeDB), elemRange.Max}
+		}
+		r.Min = math.Max(r.Min, elemRange.Min)
+		r.Max = math.Min(r.Max, elemRange.Max)
+	} else if r == (Range{}) {
+		r = Range{-lmsRangeDB, 0}
+	}
+	m.rng = r
+
+	return m, nil
+}
+
+// String returns the name of the mixer and the range the volume is mapped to.
+func (m *Mixer) String() string {
+	if m.hasDB {
+		return fmt.Sprintf("%s (%v)", m.name, m.rng)
+	}
+	return fmt.Sprintf("%s (%v-%v)", m.name, m.min, m.max)
+}
+
+// dB maps a gain of audg to the range of the mixer
+func (m *Mixer) dB(gain uint32) float64 {
+	if gain == 0 {
+		return m.rng.Min
+	}
+	db := 20 * math.Log10(float64(gain)/(1<<16))
+
+	// The volume curve of the server spans lmsRangeDB, stretch it to the
+	// range of the mixer
+	db = m.rng.Max + db*(m.rng.Max-m.rng.Min)/lmsRangeDB
+	return math.Max(m.rng.Min, math.Min(m.rng.Max, db))
+}
+
+// raw maps a volume in dB to the raw range of the element
+func (m *Mixer) raw(db float64) C.long {
+	f := (db - m.rng.Min) / (m.rng.Max - m.rng.Min)
+	return m.min + C.long(math.Floor(f*float64(m.max-m.min)+0.5))
+}
+
+// SetVolume sets the volume from the 16.16 fixed point gains of audg, a gain
+// of 0 mutes the element.
+func (m *Mixer) SetVolume(left, right uint32) error {
+	mute := left == 0 && right == 0
+	if m.hasSwitch {
+		on := C.int(1)
+		if mute {
+			on = 0
+		}
+		C.snd_mixer_selem_set_playback_switch_all(m.elem, on)
+	}
+
+	if C.snd_mixer_selem_is_playback_mono(m.elem) != 0 {
+		right = left
+	}
+	channels := []struct {
+		id   C.snd_mixer_selem_channel_id_t
+		gain uint32
+	}{
+		{C.SND_MIXER_SCHN_FRONT_LEFT, left},
+		{C.SND_MIXER_SCHN_FRONT_RIGHT, right},
+	}
+	for _, ch := range channels {
+		var err C.int
+		switch {
+		case mute && !m.hasSwitch:
+			err = C.snd_mixer_selem_set_playback_volume(m.elem, ch.id, m.min)
+		case m.hasDB:
+			err = C.snd_mixer_selem_set_playback_dB(m.elem, ch.id, C.long(m.dB(ch.gain)*100), 1)
+		default:
+			err = C.snd_mixer_selem_set_playback_volume(m.elem, ch.id, m.raw(m.dB(ch.gain)))
+		}
+		if err < 0 {
+			return fmt.Errorf("mixer %s: %v", m.name, alsaError(err))
+		}
+	}
+	return nil
+}
+
+// Close closes the mixer.
+func (m *Mixer) Close() error {
+	if m.mixer != nil {
+		C.snd_mixer_close(m.mixer)
+		m.mixer = nil
+	}
+	return nil
+}
+
+// Device is an ALSA playback device.
+type Device struct {
+	Name        string
+	Description string
+}
+
+// Devices returns the ALSA playback devices, like aplay -L.
+func Devices() ([]Device, error) {
+	var hints *unsafe.Pointer
+	cpcm := C.CString("pcm")
+	defer C.free(unsafe.Pointer(cpcm))
+	if err := C.snd_device_name_hint(-1, cpcm, &hints); err < 0 {
+		return nil, alsaError(err)
+	}
+	defer C.snd_device_name_free_hint(hints)
+
+	hint := func(i C.int, id string) string {
+		cid := C.CString(id)
+		defer C.free(unsafe.Pointer(cid))
+		return hintString(C.get_hint(hints, i, cid))
+	}
+
+	var devices []Device
+	for i := C.int(0); i < C.count_hints(hints); i++ {
+		name := hint(i, "NAME")
+		desc := hint(i, "DESC")
+		ioid := hint(i, "IOID")
+
+		// No IOID means both input and output
+		if name == "" || ioid == "Input" {
+			continue
+		}
+		devices = append(devices, Device{name, strings.Replace(desc, "\n", ", ", -1)})
+	}
+	return devices, nil
+}
+
+// hintString converts and frees a hint
+func hintString(s *C.char) string {
+	if s == nil {
+		return ""
+	}
+	defer C.free(unsafe.Pointer(s))
+	return C.GoString(s)
+}
+
+// Card is a sound card with its mixer elements.
+type Card struct {
+	Device string // control device, e.g. hw:0
+	Name   string
+	Mixers []string // elements with a playback volume
+}
+
+// Cards returns the sound cards and their mixer elements.
+func Cards() ([]Card, error) {
+	var cards []Card
+	card := C.int(-1)
+	for {
+		if err := C.snd_card_next(&card); err < 0 {
+			return cards, alsaError(err)
+		}
+		if card < 0 {
+			return cards, nil
+		}
+
+		c := Card{Device: "hw:" + strconv.Itoa(int(card))}
+		var name *C.char
+		if C.snd_card_get_name(card, &name) == 0 {
+			c.Name = hintString(name)
+		}
+		c.Mixers = mixerElements(c.Device)
+		cards = append(cards, c)
+	}
+}
+
+// mixerElements returns the names of the elements of card with a playback
+// volume
+func mixerElements(card string) (names []string) {
+	var mixer *C.snd_mixer_t
+	ccard := C.CString(card)
+	defer C.free(unsafe.Pointer(ccard))
+	if C.open_mixer(ccard, &mixer) < 0 {
+		return nil
+	}
+	defer C.snd_mixer_close(mixer)
+
+	for elem := C.snd_mixer_first_elem(mixer); elem != nil; elem = C.snd_mixer_elem_next(elem) {
+		if C.snd_mixer_selem_is_active(elem) == 0 || C.snd_mixer_selem_has_playback_volume(elem) == 0 {
+			continue
+		}
+		name := C.GoString(C.snd_mixer_selem_get_name(elem))
+		if index := C.snd_mixer_selem_get_index(elem); index > 0 {
+			name += "," + strconv.Itoa(int(index))
+		}
+		names = append(names, name)
+	}
+	return names
+}
@@ -0,0 +1,129 @@
+/*
+ *  (c) 2012 Bart Lauret
+ *
+ *  This file is part of slimgo.
+ *
+ *  slimgo is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  slimgo is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with slimgo.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package output
+
+import (
+	"errors"
+	"github.com/terual/slimgo/pcm"
+	"io"
+	"log"
+)
+
+// maxSoftRate is the highest sample rate of the outputs without hardware,
+// which is the highest rate a strm can ask for
+const maxSoftRate = 192000
+
+// nullOutput discards the audio, but takes as long as playing it would.
+type nullOutput struct {
+	clock  clock
+	format pcm.Format
+}
+
+// NewNull returns an output which discards the audio.
+func NewNull() Output {
+	return new(nullOutput)
+}
+
+func (o *nullOutput) Open(format pcm.Format) error {
+	if !format.Valid() {
+		return errors.New("output: invalid format " + format.String())
+	}
+	o.format = format
+	o.clock.reset(format.Rate)
+	return nil
+}
+
+// frames returns the number of whole frames in data
+func (o *nullOutput) frames(data []byte) int {
+	if o.format.FrameSize() == 0 {
+		return 0
+	}
+	return len(data) / o.format.FrameSize()
+}
+
+func (o *nullOutput) Write(data []byte) (n int, err error) {
+	frames := o.frames(data)
+	o.clock.write(frames)
+	return frames * o.format.FrameSize(), nil
+}
+
+func (o *nullOutput) Delay() (frames int, err error) {
+	return o.clock.delay(), nil
+}
+
+func (o *nullOutput) BufferSize() int {
+	return o.clock.bufferSize()
+}
+
+func (o *nullOutput) Pause() error {
+	o.clock.pause()
+	return nil
+}
+
+func (o *nullOutput) Unpause() error {
+	o.clock.unpause()
+	return nil
+}
+
+func (o *nullOutput) Drop() error {
+	o.clock.drop()
+	return nil
+}
+
+func (o *nullOutput) Drain() error {
+	o.clock.drain()
+	return nil
+}
+
+func (o *nullOutput) MaxSampleRate() int {
+	return maxSoftRate
+}
+
+func (o *nullOutput) Close() error {
+	return nil
+}
+
+// rawOutput writes the samples as they are, e.g. to pipe them into
+// another program.
+type rawOutput struct {
+	nullOutput
+	w io.WriteCloser
+}
+
+// NewRaw returns an output which writes the raw samples to w.
+func NewRaw(w io.WriteCloser) Output {
+	return &rawOutput{w: w}
+}
+
+func (o *rawOutput) Open(format pcm.Format) error {
+	if format != o.format {
+		log.Printf("Raw output is now %v", format)
+	}
+	return o.nullOutput.Open(format)
+}
+
+func (o *rawOutput) Write(data []byte) (n int, err error) {
+	frames := o.frames(data)
+	o.clock.write(frames)
+	return o.w.Write(data[:frames*o.format.FrameSize()])
+}
+
+func (o *rawOutput) Close() error {
+	return o.w.Close()
+}
@@ -0,0 +1,82 @@
+/*
+ *  (c) 2012 Bart Lauret
+ *
+ *  This file is part of slimgo.
+ *
+ *  slimgo is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  slimgo is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with slimgo.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package output implements the sinks the player writes PCM audio to: an
+// ALSA device, a WAV file, a raw stream on stdout or nothing at all.
+package output
+
+import (
+	"github.com/terual/slimgo/pcm"
+	"os"
+	"strings"
+)
+
+// Output is a sink for PCM audio.
+type Output interface {
+	// Open prepares the output for audio in format. It is called again
+	// whenever the format changes.
+	Open(format pcm.Format) error
+
+	// Write writes whole frames and returns the number of bytes written.
+	Write(data []byte) (n int, err error)
+
+	// Delay returns the number of frames written but not yet played.
+	Delay() (frames int, err error)
+
+	// BufferSize returns the size of the output buffer in frames.
+	BufferSize() int
+
+	Pause() error
+	Unpause() error
+
+	// Drop discards the frames not yet played, Drain waits for them.
+	Drop() error
+	Drain() error
+
+	// MaxSampleRate returns the highest sample rate the output can play.
+	MaxSampleRate() int
+
+	Close() error
+}
+
+// New opens the output given by spec:
+//
+//	null         discard the audio
+//	wav:<path>   write the audio to a WAV file
+//	raw:-        write the raw samples to stdout, raw:<path> to a file
+//	<device>     play the audio on an ALSA device, e.g. hw:0,0
+func New(spec string) (Output, error) {
+	switch {
+	case spec == "null":
+		return NewNull(), nil
+	case strings.HasPrefix(spec, "wav:"):
+		return NewWAV(spec[len("wav:"):]), nil
+	case spec == "raw:-":
+		return NewRaw(os.Stdout), nil
+	case strings.HasPrefix(spec, "raw:"):
+		f, err := os.Create(spec[len("raw:"):])
+		if err != nil {
+			return nil, err
+		}
+		return NewRaw(f), nil
+	case strings.HasPrefix(spec, "alsa:"):
+		return NewALSA(spec[len("alsa:"):])
+	}
+	return NewALSA(spec)
+}
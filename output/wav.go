@@ -0,0 +1,157 @@
+/*
+ *  (c) 2012 Bart Lauret
+ *
+ *  This file is part of slimgo.
+ *
+ *  slimgo is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  slimgo is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with slimgo.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package output
+
+import (
+	"encoding/binary"
+	"github.com/terual/slimgo/pcm"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// wavHeaderLen is the size of the header written by wavOutput
+const wavHeaderLen = 44
+
+// wavOutput writes the audio to a WAV file. A WAV file has a single format,
+// so every change of format starts a new file: out.wav, out-2.wav, ...
+type wavOutput struct {
+	nullOutput
+	path     string
+	files    int
+	f        *os.File
+	dataSize uint32
+	buf      []byte
+}
+
+// NewWAV returns an output which writes the audio to the WAV file path.
+func NewWAV(path string) Output {
+	return &wavOutput{path: path}
+}
+
+// name returns the name of the n-th file
+func (o *wavOutput) name(n int) string {
+	if n == 1 {
+		return o.path
+	}
+	ext := filepath.Ext(o.path)
+	return strings.TrimSuffix(o.path, ext) + "-" + strconv.Itoa(n) + ext
+}
+
+func (o *wavOutput) Open(format pcm.Format) error {
+	if o.f != nil && format == o.format {
+		return o.nullOutput.Open(format)
+	}
+	if !format.Valid() {
+		return o.nullOutput.Open(format)
+	}
+	if err := o.finish(); err != nil {
+		return err
+	}
+	if err := o.nullOutput.Open(format); err != nil {
+		return err
+	}
+
+	o.files++
+	f, err := os.Create(o.name(o.files))
+	if err != nil {
+		return err
+	}
+	o.f = f
+	o.dataSize = 0
+	log.Printf("Writing %v to %s", format, f.Name())
+
+	return o.writeHeader()
+}
+
+// writeHeader writes the header at the start of the file, the sizes are
+// updated when the file is finished
+func (o *wavOutput) writeHeader() error {
+	sampleSize := o.format.SampleFormat.Size()
+
+	h := make([]byte, wavHeaderLen)
+	copy(h[0:4], "RIFF")
+	binary.LittleEndian.PutUint32(h[4:8], wavHeaderLen-8+o.dataSize)
+	copy(h[8:12], "WAVE")
+	copy(h[12:16], "fmt ")
+	binary.LittleEndian.PutUint32(h[16:20], 16)
+	binary.LittleEndian.PutUint16(h[20:22], 1) // PCM
+	binary.LittleEndian.PutUint16(h[22:24], uint16(o.format.Channels))
+	binary.LittleEndian.PutUint32(h[24:28], uint32(o.format.Rate))
+	binary.LittleEndian.PutUint32(h[28:32], uint32(o.format.Rate*o.format.FrameSize()))
+	binary.LittleEndian.PutUint16(h[32:34], uint16(o.format.FrameSize()))
+	binary.LittleEndian.PutUint16(h[34:36], uint16(sampleSize*8))
+	copy(h[36:40], "data")
+	binary.LittleEndian.PutUint32(h[40:44], o.dataSize)
+
+	_, err := o.f.WriteAt(h, 0)
+	return err
+}
+
+// finish updates the header of the current file and closes it
+func (o *wavOutput) finish() error {
+	if o.f == nil {
+		return nil
+	}
+	err := o.writeHeader()
+	if cerr := o.f.Close(); err == nil {
+		err = cerr
+	}
+	o.f = nil
+	return err
+}
+
+func (o *wavOutput) Write(data []byte) (n int, err error) {
+	frames := o.frames(data)
+	o.clock.write(frames)
+	n = frames * o.format.FrameSize()
+
+	// WAV samples are little-endian, and unsigned when 8 bits
+	data = data[:n]
+	size := o.format.SampleFormat.Size()
+	if o.format.SampleFormat == pcm.S8 || o.format.SampleFormat.BigEndian() {
+		if cap(o.buf) < n {
+			o.buf = make([]byte, n)
+		}
+		buf := o.buf[:n]
+		for i := 0; i < n; i += size {
+			for j := 0; j < size; j++ {
+				buf[i+j] = data[i+size-1-j]
+			}
+		}
+		if o.format.SampleFormat == pcm.S8 {
+			for i := range buf {
+				buf[i] ^= 0x80
+			}
+		}
+		data = buf
+	}
+
+	if _, err = o.f.WriteAt(data, wavHeaderLen+int64(o.dataSize)); err != nil {
+		return 0, err
+	}
+	o.dataSize += uint32(n)
+	return n, nil
+}
+
+func (o *wavOutput) Close() error {
+	return o.finish()
+}
@@ -0,0 +1,129 @@
+/*
+ *  (c) 2012 Bart Lauret
+ *
+ *  This file is part of slimgo.
+ *
+ *  slimgo is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  slimgo is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with slimgo.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package pcm describes the format of interleaved PCM audio.
+package pcm
+
+import (
+	"fmt"
+)
+
+// SampleFormat is the encoding of a single sample.
+type SampleFormat int
+
+const (
+	SampleFormatUnknown SampleFormat = iota
+	S8
+	S16LE
+	S16BE
+	S24_3LE // 24 bits in 3 bytes
+	S24_3BE
+	S32LE
+	S32BE
+)
+
+var sampleFormatNames = map[SampleFormat]string{
+	SampleFormatUnknown: "unknown",
+	S8:                  "S8",
+	S16LE:               "S16_LE",
+	S16BE:               "S16_BE",
+	S24_3LE:             "S24_3LE",
+	S24_3BE:             "S24_3BE",
+	S32LE:               "S32_LE",
+	S32BE:               "S32_BE",
+}
+
+func (f SampleFormat) String() string {
+	if name, ok := sampleFormatNames[f]; ok {
+		return name
+	}
+	return fmt.Sprintf("SampleFormat(%d)", int(f))
+}
+
+// Size returns the size of a sample in bytes.
+func (f SampleFormat) Size() int {
+	switch f {
+	case S8:
+		return 1
+	case S16LE, S16BE:
+		return 2
+	case S24_3LE, S24_3BE:
+		return 3
+	case S32LE, S32BE:
+		return 4
+	}
+	return 0
+}
+
+// BigEndian reports whether the samples are big-endian.
+func (f SampleFormat) BigEndian() bool {
+	return f == S16BE || f == S24_3BE || f == S32BE
+}
+
+// Format is the format of a PCM stream.
+type Format struct {
+	SampleFormat SampleFormat
+	Rate         int
+	Channels     int
+}
+
+func (f Format) String() string {
+	return fmt.Sprintf("%v %vHz %vch", f.SampleFormat, f.Rate, f.Channels)
+}
+
+// Valid reports whether f describes a stream that can be played.
+func (f Format) Valid() bool {
+	return f.SampleFormat.Size() > 0 && f.Rate > 0 && f.Channels > 0
+}
+
+// FrameSize returns the size in bytes of one sample for every channel.
+func (f Format) FrameSize() int {
+	return f.SampleFormat.Size() * f.Channels
+}
+
+// Sample returns the sample in b, sign extended to 32 bits.
+func Sample(b []byte, f SampleFormat) int32 {
+	var s uint32
+	if f.BigEndian() {
+		for _, x := range b {
+			s = s<<8 | uint32(x)
+		}
+	} else {
+		for i := len(b) - 1; i >= 0; i-- {
+			s = s<<8 | uint32(b[i])
+		}
+	}
+	shift := uint(32 - 8*len(b))
+	return int32(s<<shift) >> shift
+}
+
+// PutSample writes the lowest bits of s to b.
+func PutSample(b []byte, f SampleFormat, s int32) {
+	if f.BigEndian() {
+		for i := len(b) - 1; i >= 0; i-- {
+			b[i] = byte(s)
+			s >>= 8
+		}
+	} else {
+		for i := range b {
+			b[i] = byte(s)
+			s >>= 8
+		}
+	}
+}
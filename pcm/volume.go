@@ -0,0 +1,116 @@
+/*
+ *  (c) 2012 Bart Lauret
+ *
+ *  This file is part of slimgo.
+ *
+ *  slimgo is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  slimgo is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with slimgo.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package pcm
+
+import (
+	"sync"
+)
+
+// Unity is a gain of 1.0 in 16.16 fixed point.
+const Unity = 1 << 16
+
+// rampMillis is how long a change of gain takes, a sudden change is heard as
+// a click
+const rampMillis = 20
+
+// Volume applies a gain in 16.16 fixed point to PCM audio. A new gain is
+// reached by ramping to it over a few milliseconds.
+type Volume struct {
+	mu     sync.Mutex
+	gain   [2]int64 // left and right
+	target [2]int64
+	step   [2]int64 // per frame, while ramping
+	ramp   int      // frames left to ramp, -1 when the ramp is not started
+	bypass bool
+}
+
+// NewVolume returns a Volume with a gain of Unity.
+func NewVolume() *Volume {
+	return &Volume{
+		gain:   [2]int64{Unity, Unity},
+		target: [2]int64{Unity, Unity},
+	}
+}
+
+// Set sets the gain of the left and right channel.
+func (v *Volume) Set(left, right uint32) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.target = [2]int64{int64(left), int64(right)}
+	v.ramp = -1
+}
+
+// SetBypass leaves the audio untouched while bypass is set, for a fixed
+// output level.
+func (v *Volume) SetBypass(bypass bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.bypass = bypass
+}
+
+// Apply applies the gain to the whole frames in data, in place.
+func (v *Volume) Apply(data []byte, format Format) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	size := format.SampleFormat.Size()
+	if v.bypass || size == 0 || format.Channels == 0 {
+		return
+	}
+	if v.ramp == 0 && v.gain[0] == Unity && v.gain[1] == Unity {
+		return
+	}
+	if v.ramp < 0 {
+		v.ramp = format.Rate * rampMillis / 1000
+		if v.ramp == 0 {
+			v.ramp = 1
+		}
+		for c := range v.step {
+			v.step[c] = (v.target[c] - v.gain[c]) / int64(v.ramp)
+		}
+	}
+
+	// Samples are scaled as 32-bits, so every format is handled alike
+	shift := uint(32 - 8*size)
+	frameSize := size * format.Channels
+	for i := 0; i+frameSize <= len(data); i += frameSize {
+		if v.ramp > 0 {
+			v.ramp--
+			for c := range v.gain {
+				v.gain[c] += v.step[c]
+				if v.ramp == 0 {
+					v.gain[c] = v.target[c]
+				}
+			}
+		}
+		for c := 0; c < format.Channels; c++ {
+			sample := data[i+c*size : i+(c+1)*size]
+			s := int64(Sample(sample, format.SampleFormat) << shift)
+			s = s * v.gain[c%2] >> 16
+			if s > 1<<31-1 {
+				s = 1<<31 - 1
+			} else if s < -1<<31 {
+				s = -1 << 31
+			}
+			PutSample(sample, format.SampleFormat, int32(s)>>shift)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+/*
+ *  (c) 2012 Bart Lauret
+ *
+ *  This file is part of slimgo.
+ *
+ *  slimgo is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  slimgo is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with slimgo.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package player
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// backoff is a jittered exponential backoff between reconnects
+type backoff struct {
+	Min     time.Duration
+	Max     time.Duration
+	attempt uint
+}
+
+// next returns the time to wait before the next attempt. The wait doubles
+// every attempt up to Max, and is randomised between half and the full wait
+// so that players restarted together do not reconnect together.
+func (b *backoff) next() time.Duration {
+	d := b.Min << b.attempt
+	if d <= 0 || d >= b.Max {
+		d = b.Max
+	} else {
+		b.attempt++
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// reset starts the backoff over after a successful attempt
+func (b *backoff) reset() {
+	b.attempt = 0
+}
+
+// sleep waits for d, or until ctx is done
+func sleep(ctx context.Context, d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+	case <-t.C:
+	}
+}
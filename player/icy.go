@@ -0,0 +1,100 @@
+/*
+ *  (c) 2012 Bart Lauret
+ *
+ *  This file is part of slimgo.
+ *
+ *  slimgo is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  slimgo is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with slimgo.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package player
+
+import (
+	"bytes"
+	"io"
+	"strings"
+)
+
+// icyReader strips the shoutcast metadata from a stream. A block of
+// metadata follows every metaint bytes of audio, it starts with its length
+// in units of 16 bytes.
+type icyReader struct {
+	r       io.Reader
+	metaint int
+	left    int               // bytes of audio until the next block
+	meta    func(data []byte) // called for every block which is not empty
+}
+
+func newICYReader(r io.Reader, metaint int, meta func(data []byte)) *icyReader {
+	return &icyReader{r: r, metaint: metaint, left: metaint, meta: meta}
+}
+
+func (r *icyReader) Read(p []byte) (n int, err error) {
+	if r.left == 0 {
+		if err = r.readMeta(); err != nil {
+			return 0, err
+		}
+		r.left = r.metaint
+	}
+	if len(p) > r.left {
+		p = p[:r.left]
+	}
+	n, err = r.r.Read(p)
+	r.left -= n
+	return n, err
+}
+
+// readMeta reads a block of metadata
+func (r *icyReader) readMeta() error {
+	var size [1]byte
+	if _, err := io.ReadFull(r.r, size[:]); err != nil {
+		return err
+	}
+	if size[0] == 0 {
+		return nil
+	}
+	data := make([]byte, int(size[0])*16)
+	if _, err := io.ReadFull(r.r, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	// The block is padded with zeros
+	if data = bytes.TrimRight(data, "\x00"); len(data) > 0 {
+		r.meta(data)
+	}
+	return nil
+}
+
+// parseICY parses metadata like StreamTitle='Artist - Title';StreamUrl='url';
+// the values may contain quotes and semicolons themselves.
+func parseICY(data string) map[string]string {
+	fields := make(map[string]string)
+	for {
+		i := strings.Index(data, "='")
+		if i < 0 {
+			return fields
+		}
+		key := strings.TrimSpace(data[:i])
+		data = data[i+2:]
+
+		j := strings.Index(data, "';")
+		if j < 0 {
+			// The last value may lack the semicolon
+			fields[key] = strings.TrimSuffix(strings.TrimSpace(data), "'")
+			return fields
+		}
+		fields[key] = data[:j]
+		data = data[j+2:]
+	}
+}
@@ -0,0 +1,141 @@
+/*
+ *  (c) 2012 Bart Lauret
+ *
+ *  This file is part of slimgo.
+ *
+ *  slimgo is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  slimgo is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with slimgo.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package player
+
+import (
+	"bytes"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"testing/iotest"
+)
+
+// icyStream returns a stream of audio with a block of metadata after every
+// metaint bytes, of which the empty metas have no data
+func icyStream(audio []byte, metaint int, metas []string) []byte {
+	var stream []byte
+	for i, meta := range metas {
+		stream = append(stream, audio[i*metaint:(i+1)*metaint]...)
+		size := (len(meta) + 15) / 16
+		block := make([]byte, 1+size*16)
+		block[0] = byte(size)
+		copy(block[1:], meta)
+		stream = append(stream, block...)
+	}
+	return stream
+}
+
+func TestICYReader(t *testing.T) {
+	const metaint = 16
+	audio := sequence(0, 5*metaint)
+	metas := []string{"", "StreamTitle='One';", "", "StreamTitle='Two - Three';StreamUrl='http://radio/';", ""}
+	stream := icyStream(audio, metaint, metas)
+
+	// The blocks are split over reads of the stream, or reads of the
+	// audio span them
+	readers := map[string]func(io.Reader) io.Reader{
+		"whole":    func(r io.Reader) io.Reader { return r },
+		"one byte": iotest.OneByteReader,
+		"half":     iotest.HalfReader,
+	}
+	for in, inReader := range readers {
+		for out, outReader := range readers {
+			t.Run(in+"/"+out, func(t *testing.T) {
+				var got []string
+				r := newICYReader(inReader(bytes.NewReader(stream)), metaint, func(data []byte) {
+					got = append(got, string(data))
+				})
+				data, err := io.ReadAll(outReader(r))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(data, audio) {
+					t.Errorf("read %v, want %v", data, audio)
+				}
+				if want := []string{metas[1], metas[3]}; !slices.Equal(got, want) {
+					t.Errorf("metadata %q, want %q", got, want)
+				}
+			})
+		}
+	}
+}
+
+func TestICYReaderTruncated(t *testing.T) {
+	stream := icyStream(sequence(0, 16), 16, []string{"StreamTitle='One';"})
+	r := newICYReader(bytes.NewReader(stream[:len(stream)-1]), 16, func([]byte) {})
+	if _, err := io.ReadAll(r); err != io.ErrUnexpectedEOF {
+		t.Errorf("error %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+func TestParseICY(t *testing.T) {
+	tests := []struct {
+		data string
+		want map[string]string
+	}{
+		{"StreamTitle='Artist - Title';", map[string]string{"StreamTitle": "Artist - Title"}},
+		{"StreamTitle='A';StreamUrl='http://radio/';",
+			map[string]string{"StreamTitle": "A", "StreamUrl": "http://radio/"}},
+		{"StreamTitle='Rock 'n' Roll; Live';", map[string]string{"StreamTitle": "Rock 'n' Roll; Live"}},
+		{"StreamTitle='No semicolon'", map[string]string{"StreamTitle": "No semicolon"}},
+		{"StreamTitle='';", map[string]string{"StreamTitle": ""}},
+		{"garbage", map[string]string{}},
+	}
+	for _, tt := range tests {
+		if got := parseICY(tt.data); !maps.Equal(got, tt.want) {
+			t.Errorf("parseICY(%q) = %q, want %q", tt.data, got, tt.want)
+		}
+	}
+}
+
+// TestStrmICY checks that the metadata of a radio stream is not played, but
+// sent to the server as META
+func TestStrmICY(t *testing.T) {
+	audio := sequence(0, 4*1024)
+	metas := []string{"", "StreamTitle='One';StreamUrl='http://radio/';", "", ""}
+	web := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Icy-Metaint", "1024")
+		w.Write(icyStream(audio, 1024, metas))
+	}))
+	defer web.Close()
+
+	out := new(testOutput)
+	p := newTestPlayer(t, out)
+	srv := newTestServer(t, p)
+
+	srv.send(testStrm(web.Listener.Addr(), "GET /stream HTTP/1.0\r\nIcy-MetaData: 1\r\n\r\n"))
+	srv.wait("STMh")
+	<-srv.data // of the RESP
+	srv.wait("META")
+	if data := <-srv.data; string(data) != metas[1] {
+		t.Errorf("META %q, want %q", data, metas[1])
+	}
+	srv.wait("STMd")
+	waitPlayed(t, p.buffer.Stream.Load())
+	if got := out.written(); !bytes.Equal(got, audio) {
+		t.Errorf("wrote %d bytes, want the %d bytes of audio", len(got), len(audio))
+	}
+	if title := p.Title(); title != "One" {
+		t.Errorf("title %q, want %q", title, "One")
+	}
+}
@@ -0,0 +1,409 @@
+/*
+ *  (c) 2012 Bart Lauret
+ *
+ *  This file is part of slimgo.
+ *
+ *  slimgo is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  slimgo is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with slimgo.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package player implements a Squeezebox player. Every Player owns its own
+// connection to the server, output and buffer, so several players can
+// run in one process.
+package player
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"github.com/terual/slimgo/output"
+	"github.com/terual/slimgo/pcm"
+	"github.com/terual/slimgo/slimproto"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// startTime is used by jiffies()
+var startTime time.Time = time.Now()
+
+// Config holds the settings of a single player.
+type Config struct {
+	Name   string    // name used in the log, defaults to the MAC address
+	MAC    [6]uint8  // used by the server to distinguish players
+	UUID   [16]uint8 // optional, sent in HELO
+	Server net.IP    // IP-address of the Logitech Media Server
+	Port   int       // port of the Logitech Media Server, defaults to 3483
+	Device string    // output, see output.New
+	Debug  bool      // log debug messages
+
+	// Output overrides Device, e.g. to play to an output of your own
+	Output output.Output
+
+	// Mixer is an ALSA mixer control to set the volume with instead of in
+	// software, see output.OpenMixer
+	Mixer      string
+	MixerRange output.Range
+
+	// Sizes in bytes of the buffer of the stream from the server and of
+	// the buffer of decoded audio, 0 for the defaults
+	StreamBufSize int
+	OutputBufSize int
+
+	// TLS is used for HTTPS streams, nil verifies them with the CA
+	// certificates of the system
+	TLS *tls.Config
+}
+
+// Default sizes of the buffers
+const (
+	DefaultStreamBufSize = 2 * 1024 * 1024
+	DefaultOutputBufSize = 4 * 1024 * 1024
+)
+
+// slimaudio struct
+type audio struct {
+	Output        output.Output
+	Volume        *pcm.Volume
+	Mixer         *output.Mixer // nil for software volume
+	Pcmsamplesize uint8
+	Pcmsamplerate uint8
+	Pcmchannels   uint8
+	Pcmendian     uint8
+	MaxRate       int
+
+	// mu guards the fields below, which are shared by the output
+	// goroutine, the receive loop and the status timer
+	mu                sync.Mutex
+	State             string
+	Format            pcm.Format // format the output is opened with
+	Skip              int        // frames to skip, set by strm a
+	FramesWritten     int
+	LastFramesWritten int
+	NewTrack          bool
+	resume            chan struct{} // closed when leaving PAUSED
+}
+
+// state returns the state of the playback
+func (a *audio) state() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.State
+}
+
+// setState sets the state of the playback, if it is one of from or from is
+// empty. It reports whether the state is set.
+func (a *audio) setState(state string, from ...string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if len(from) > 0 {
+		found := false
+		for _, f := range from {
+			found = found || a.State == f
+		}
+		if !found {
+			return false
+		}
+	}
+	a.setStateLocked(state)
+	return true
+}
+
+// setStateLocked sets the state with mu held, the output resumes when the
+// state leaves PAUSED
+func (a *audio) setStateLocked(state string) {
+	if state == "PAUSED" && a.resume == nil {
+		a.resume = make(chan struct{})
+	}
+	if state != "PAUSED" && a.resume != nil {
+		close(a.resume)
+		a.resume = nil
+	}
+	a.State = state
+}
+
+// paused returns a channel which is closed when the playback is resumed, or
+// nil if it is not paused
+func (a *audio) paused() <-chan struct{} {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.resume
+}
+
+// resumeAfter resumes the playback after d, unless it is resumed or
+// paused again before
+func (a *audio) resumeAfter(d time.Duration) {
+	a.mu.Lock()
+	resume := a.resume
+	a.mu.Unlock()
+	if resume == nil {
+		return
+	}
+
+	time.AfterFunc(d, func() {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		if a.resume != resume {
+			return
+		}
+		_ = a.Output.Unpause()
+		a.setStateLocked("PLAYING")
+	})
+}
+
+// slimproto struct
+type proto struct {
+	mu          sync.Mutex // guards Conn, Addr and SyncgroupID
+	Conn        net.Conn
+	Addr        net.IP
+	Port        int
+	SyncgroupID string // sync group to join, sent by serv
+}
+
+// slimbuffer struct
+type buffer struct {
+	Stream        atomic.Pointer[stream] // stream being received
+	Output        atomic.Pointer[ring]   // decoded audio being played
+	Title         atomic.Pointer[string] // of the shoutcast metadata
+	Init          atomic.Bool            // set when the threshold of a stream is buffered
+	BytesReceived atomic.Uint64          // of the current stream
+}
+
+// Player is a single Squeezebox player.
+type Player struct {
+	config Config
+	log    *log.Logger
+
+	audio  audio
+	server proto
+	buffer buffer
+}
+
+// New returns a player for config and opens its output.
+func New(config Config) (*Player, error) {
+	if config.Port == 0 {
+		config.Port = slimproto.Port
+	}
+	if config.Device == "" {
+		config.Device = "default"
+	}
+	if config.Name == "" {
+		config.Name = net.HardwareAddr(config.MAC[:]).String()
+	}
+	if config.StreamBufSize <= 0 {
+		config.StreamBufSize = DefaultStreamBufSize
+	}
+	if config.OutputBufSize <= 0 {
+		config.OutputBufSize = DefaultOutputBufSize
+	}
+
+	p := &Player{
+		config: config,
+		log:    log.New(os.Stderr, "["+config.Name+"] ", log.LstdFlags),
+	}
+	p.audio.Volume = pcm.NewVolume()
+	p.server.Addr = config.Server
+	p.server.Port = config.Port
+
+	// Open the output
+	p.audio.Output = config.Output
+	if p.audio.Output == nil {
+		if config.Device == "default" {
+			p.log.Println("Using output device 'default', consider using 'hw:0,0' to avoid conversion in ALSA")
+		}
+		out, err := output.New(config.Device)
+		if err != nil {
+			return nil, fmt.Errorf("open %s: %v", config.Device, err)
+		}
+		p.audio.Output = out
+		if p.config.Debug {
+			p.log.Printf("Output %s opened", config.Device)
+		}
+	}
+	p.audio.MaxRate = p.audio.Output.MaxSampleRate()
+
+	// With a hardware mixer the audio is left untouched
+	if config.Mixer != "" {
+		mixer, err := output.OpenMixer(config.Mixer, config.Device, config.MixerRange)
+		if err != nil {
+			p.audio.Output.Close()
+			return nil, err
+		}
+		p.audio.Mixer = mixer
+		p.audio.Volume.SetBypass(true)
+		p.log.Printf("Using mixer %v for the volume", mixer)
+	}
+	p.log.Printf("Maximum sample rate of %s: %v Hz.", config.Device, p.audio.MaxRate)
+
+	return p, nil
+}
+
+// Close stops the stream and closes the output and mixer of the player.
+func (p *Player) Close() error {
+	p.slimbufferStop()
+	if p.audio.Mixer != nil {
+		p.audio.Mixer.Close()
+	}
+	return p.slimaudioClose()
+}
+
+// Title returns the title of the radio stream being received, as sent in
+// its shoutcast metadata, or "".
+func (p *Player) Title() string {
+	if title := p.buffer.Title.Load(); title != nil {
+		return *title
+	}
+	return ""
+}
+
+// Run connects to the server and plays until ctx is done. A BYE! message is
+// sent to the server before returning.
+func (p *Player) Run(ctx context.Context) error {
+	if p.server.Addr == nil {
+		return fmt.Errorf("no server address for %s", p.config.Name)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			// First send a BYE! msg to the server, closing the
+			// connection ends the main loop
+			_ = p.slimprotoBye()
+			p.slimprotoClose()
+		case <-done:
+		}
+	}()
+
+	p.slimproto_main(ctx)
+	return ctx.Err()
+}
+
+// jiffies returns a 1kHz counter since start of program
+func jiffies() uint32 {
+	return uint32(time.Now().Sub(startTime) / time.Millisecond)
+}
+
+// Timeouts of the connection to the server
+const (
+	dialTimeout = 10 * time.Second
+
+	// The server sends at least a strm t every few seconds, so a
+	// connection without any message for this long is dead
+	heartbeatTimeout = 35 * time.Second
+)
+
+// Main loop, keeps the player connected to the server until ctx is done
+func (p *Player) slimproto_main(ctx context.Context) {
+
+	retry := backoff{Min: 500 * time.Millisecond, Max: 30 * time.Second}
+
+	// set when connecting to the server we were connected to before
+	var reconnect = false
+	// set when the server told us to switch to another server
+	var switched = false
+
+	for ctx.Err() == nil {
+
+		// A serv changes the address
+		p.server.mu.Lock()
+		addr := p.server.Addr
+		p.server.mu.Unlock()
+
+		if p.config.Debug {
+			p.log.Printf("Using %v:%v for slimproto\n", addr, p.server.Port)
+		}
+
+		err := p.slimprotoConnect(ctx, addr, p.server.Port)
+		if err != nil {
+			p.log.Println("Cannot connect to slimproto:", err)
+			sleep(ctx, retry.next())
+			continue
+		}
+
+		// On a reconnect the server resumes the stream, which is still
+		// playing from the buffer, if the bytes received match
+		var bytesReceived uint64
+		if reconnect {
+			bytesReceived = p.buffer.BytesReceived.Load()
+		}
+		err = p.slimprotoHello(reconnect || switched, bytesReceived)
+		if err != nil {
+			p.log.Println("Handshake failed, trying again:", err)
+			p.slimprotoClose()
+			sleep(ctx, retry.next())
+			continue
+		} else {
+			if p.config.Debug {
+				p.log.Println("HELO send succesfully")
+			}
+			switched = false
+		}
+
+		done := make(chan struct{})
+		go p.statusTimer(done)
+		for {
+			p.server.Conn.SetReadDeadline(time.Now().Add(heartbeatTimeout))
+			err = p.slimprotoRecv()
+			if err != nil {
+				break
+			}
+			retry.reset()
+		}
+		close(done)
+		p.slimprotoClose()
+
+		if ctx.Err() != nil {
+			return
+		}
+		switch e, _ := err.(net.Error); {
+		case err == errServerSwitch:
+			// Connect to the new server right away
+			switched = true
+			reconnect = false
+			retry.reset()
+			continue
+		case e != nil && e.Timeout():
+			p.log.Println("No messages from the server, connection is dead")
+		default:
+			p.log.Println("Slimproto error", err)
+		}
+
+		reconnect = true
+		sleep(ctx, retry.next())
+	}
+
+}
+
+// statusTimer sends a STMt every second while a stream is playing, so that
+// the server always knows the state of the buffers and the elapsed time
+func (p *Player) statusTimer(done chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if p.buffer.Init.Load() && p.audio.state() != "STOPPED" {
+				_ = p.slimprotoSend(0, "STMt")
+			}
+		}
+	}
+}
@@ -0,0 +1,262 @@
+/*
+ *  (c) 2012 Bart Lauret
+ *
+ *  This file is part of slimgo.
+ *
+ *  slimgo is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  slimgo is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with slimgo.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package player
+
+import (
+	"errors"
+	"github.com/terual/slimgo/pcm"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// errRingClosed is returned by a ring which is closed
+var errRingClosed = errors.New("ring buffer closed")
+
+// ring is a ring buffer for a single writer and a single reader, which never
+// take a lock: the positions are atomic and only wait for each other when the
+// ring is full or empty. The reader only gets whole frames of the format set
+// by the writer, or bytes without a format.
+type ring struct {
+	buf    []byte
+	r, w   atomic.Uint64 // bytes read and written since the start
+	read   atomic.Uint64 // r as last seen by the reader, the writer stays behind it
+	format atomic.Pointer[pcm.Format]
+
+	readable chan struct{} // signalled after a write
+	writable chan struct{} // signalled after a read or reset
+	done     chan struct{} // closed by close
+	once     sync.Once
+
+	eof atomic.Bool // set by closeWrite, read after the positions
+	err error       // returned by read after the data, set before eof
+}
+
+// newRing returns a ring which buffers size bytes.
+func newRing(size int) *ring {
+	b := &ring{
+		buf:      make([]byte, size),
+		readable: make(chan struct{}, 1),
+		writable: make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	return b
+}
+
+// signal wakes the other side, if it is waiting
+func signal(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
+
+// Size returns the capacity of the ring in bytes.
+func (b *ring) Size() int { return len(b.buf) }
+
+// Buffered returns the number of bytes which can be read.
+func (b *ring) Buffered() int {
+	// Load r first, so that a reset between both loads cannot make the
+	// result negative
+	r := b.r.Load()
+	return int(b.w.Load() - r)
+}
+
+// setFormat sets the format of the frames written next. It waits until the
+// frames of the previous format are read, so every read has a single format.
+// Only the writer may call it.
+func (b *ring) setFormat(format pcm.Format) error {
+	for {
+		select {
+		case <-b.done:
+			return errRingClosed
+		default:
+		}
+		if b.r.Load() == b.w.Load() {
+			break
+		}
+		select {
+		case <-b.writable:
+		case <-b.done:
+			return errRingClosed
+		}
+	}
+	b.format.Store(&format)
+	return nil
+}
+
+// closed returns a channel which is closed with the ring.
+func (b *ring) closed() <-chan struct{} { return b.done }
+
+// Write copies p into the ring, it waits for room when the ring is full. It
+// returns errRingClosed if the ring is closed before all of p is written.
+func (b *ring) Write(p []byte) (n int, err error) {
+	for n < len(p) {
+		w := b.w.Load()
+		free := len(b.buf) - int(w-b.read.Load())
+		if free == 0 {
+			select {
+			case <-b.writable:
+				continue
+			case <-b.done:
+				return n, errRingClosed
+			}
+		}
+		select {
+		case <-b.done:
+			return n, errRingClosed
+		default:
+		}
+
+		m := len(p) - n
+		if m > free {
+			m = free
+		}
+		i := int(w % uint64(len(b.buf)))
+		c := copy(b.buf[i:], p[n:n+m])
+		copy(b.buf, p[n+c:n+m])
+		b.w.Store(w + uint64(m))
+		n += m
+		signal(b.readable)
+	}
+	return n, nil
+}
+
+// ReadFrom writes the data of r into the ring until r returns an error,
+// which is returned by Read after the data as the end of the stream.
+func (b *ring) ReadFrom(r io.Reader) (n int64, err error) {
+	buf := make([]byte, 32*1024)
+	for {
+		m, rerr := r.Read(buf)
+		if m > 0 {
+			m, err = b.Write(buf[:m])
+			n += int64(m)
+			if err != nil {
+				return n, err
+			}
+		}
+		if rerr != nil {
+			b.closeWrite(rerr)
+			if rerr == io.EOF {
+				rerr = nil
+			}
+			return n, rerr
+		}
+	}
+}
+
+// fill waits until n bytes are buffered, the ring is full or the data has
+// ended. Only the reader may call it.
+func (b *ring) fill(n int) error {
+	if n > len(b.buf) {
+		n = len(b.buf)
+	}
+	for !b.eof.Load() && b.Buffered() < n {
+		select {
+		case <-b.readable:
+		case <-b.done:
+			return errRingClosed
+		}
+	}
+	return nil
+}
+
+// closeWrite ends the data in the ring, Read returns err after the data.
+func (b *ring) closeWrite(err error) {
+	b.err = err
+	b.eof.Store(true)
+	signal(b.readable)
+}
+
+// Read copies whole frames into p, see ReadFrames.
+func (b *ring) Read(p []byte) (n int, err error) {
+	n, _, err = b.ReadFrames(p)
+	return n, err
+}
+
+// ReadFrames copies whole frames into p and returns their format, it waits
+// for a frame when the ring is empty. After the data it returns the error of
+// closeWrite, a partial frame at the end is dropped.
+func (b *ring) ReadFrames(p []byte) (n int, format pcm.Format, err error) {
+	for {
+		// eof is loaded before w, so no data written before it is missed
+		eof := b.eof.Load()
+		r := b.r.Load()
+		if b.read.Swap(r) != r {
+			signal(b.writable)
+		}
+		avail := int(b.w.Load() - r)
+		if avail > len(p) {
+			avail = len(p)
+		}
+
+		// The format only changes when the ring is empty, so loaded
+		// after w it is the format of the frames up to w
+		frame := 1
+		if f := b.format.Load(); f != nil {
+			format = *f
+			frame = format.FrameSize()
+		}
+		n = avail / frame * frame
+		if n > 0 {
+			i := int(r % uint64(len(b.buf)))
+			c := copy(p[:n], b.buf[i:])
+			copy(p[c:n], b.buf)
+			// A reset while copying discards the frames copied
+			if !b.r.CompareAndSwap(r, r+uint64(n)) {
+				continue
+			}
+			b.read.Store(r + uint64(n))
+			signal(b.writable)
+			return n, format, nil
+		}
+		if len(p) < frame {
+			return 0, format, io.ErrShortBuffer
+		}
+		if eof {
+			return 0, format, b.err
+		}
+		select {
+		case <-b.readable:
+		case <-b.done:
+			return 0, format, errRingClosed
+		}
+	}
+}
+
+// reset discards the data in the ring, it may be called by anyone. The
+// reader hands the room to the writer, as the writer may still be writing
+// where the reader is reading.
+func (b *ring) reset() {
+	for {
+		r := b.r.Load()
+		if b.r.CompareAndSwap(r, b.w.Load()) {
+			break
+		}
+	}
+	signal(b.readable)
+}
+
+// close discards the data and stops the reader and the writer.
+func (b *ring) close() {
+	b.once.Do(func() {
+		close(b.done)
+	})
+	b.reset()
+}
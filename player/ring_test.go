@@ -0,0 +1,326 @@
+/*
+ *  (c) 2012 Bart Lauret
+ *
+ *  This file is part of slimgo.
+ *
+ *  slimgo is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  slimgo is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with slimgo.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package player
+
+import (
+	"bytes"
+	"errors"
+	"github.com/terual/slimgo/pcm"
+	"io"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// sequence returns n bytes counting up from start
+func sequence(start, n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(start + i)
+	}
+	return b
+}
+
+// contiguous reports whether p counts up without a gap
+func contiguous(p []byte) bool {
+	for i := 1; i < len(p); i++ {
+		if p[i] != p[i-1]+1 {
+			return false
+		}
+	}
+	return true
+}
+
+// waitFor waits until cond holds
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !cond(); {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRingWriteRead(t *testing.T) {
+	// The size is not a multiple of the writes or reads, so both wrap
+	// around the end of the buffer at any position
+	b := newRing(997)
+	const total = 1 << 20
+	go func() {
+		rnd := rand.New(rand.NewSource(1))
+		for n := 0; n < total; {
+			m := 1 + rnd.Intn(3000)
+			if m > total-n {
+				m = total - n
+			}
+			if _, err := b.Write(sequence(n, m)); err != nil {
+				t.Error(err)
+				return
+			}
+			n += m
+		}
+		b.closeWrite(io.EOF)
+	}()
+
+	rnd := rand.New(rand.NewSource(2))
+	var got int
+	for {
+		p := make([]byte, 1+rnd.Intn(2000))
+		n, err := b.Read(p)
+		if !bytes.Equal(p[:n], sequence(got, n)) {
+			t.Fatalf("read %d bytes at %d out of sequence", n, got)
+		}
+		got += n
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got != total {
+		t.Errorf("read %d bytes, want %d", got, total)
+	}
+}
+
+func TestRingFormatChange(t *testing.T) {
+	formats := []pcm.Format{
+		{SampleFormat: pcm.S16LE, Rate: 44100, Channels: 2},
+		{SampleFormat: pcm.S24_3LE, Rate: 96000, Channels: 2},
+		{SampleFormat: pcm.S8, Rate: 8000, Channels: 1},
+	}
+	const frames = 5000
+
+	// Every frame holds the index of its format in every byte, the ring
+	// holds a partial frame of every format
+	b := newRing(101)
+	go func() {
+		for i, format := range formats {
+			if err := b.setFormat(format); err != nil {
+				t.Error(err)
+				return
+			}
+			data := bytes.Repeat([]byte{byte(i)}, frames*format.FrameSize())
+			for len(data) > 0 {
+				n := min(len(data), 7*format.FrameSize())
+				if _, err := b.Write(data[:n]); err != nil {
+					t.Error(err)
+					return
+				}
+				data = data[n:]
+			}
+		}
+		b.closeWrite(io.EOF)
+	}()
+
+	got := make([]int, len(formats))
+	last := 0
+	p := make([]byte, 4099)
+	for {
+		n, format, err := b.ReadFrames(p)
+		if n > 0 {
+			i := int(p[0])
+			if formats[i] != format {
+				t.Fatalf("frames of %v read as %v", formats[i], format)
+			}
+			if n%format.FrameSize() != 0 || !bytes.Equal(p[:n], bytes.Repeat(p[:1], n)) {
+				t.Fatalf("read %d bytes not whole frames of %v", n, format)
+			}
+			if i < last {
+				t.Fatalf("frames of %v after %v", format, formats[last])
+			}
+			last = i
+			got[i] += n / format.FrameSize()
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, n := range got {
+		if n != frames {
+			t.Errorf("read %d frames of %v, want %d", n, formats[i], frames)
+		}
+	}
+}
+
+func TestRingShortBuffer(t *testing.T) {
+	b := newRing(100)
+	b.setFormat(pcm.Format{SampleFormat: pcm.S24_3LE, Rate: 44100, Channels: 2})
+	b.Write(make([]byte, 12))
+	if _, _, err := b.ReadFrames(make([]byte, 5)); err != io.ErrShortBuffer {
+		t.Errorf("read into less than a frame: error %v, want %v", err, io.ErrShortBuffer)
+	}
+}
+
+func TestRingFill(t *testing.T) {
+	// The data ends before the threshold
+	b := newRing(100)
+	go func() {
+		b.Write(sequence(0, 10))
+		b.closeWrite(io.EOF)
+	}()
+	if err := b.fill(50); err != nil {
+		t.Fatal(err)
+	}
+	p := make([]byte, 100)
+	if n, err := b.Read(p); n != 10 || err != nil {
+		t.Errorf("read %d bytes, %v, want 10 bytes", n, err)
+	}
+	if n, err := b.Read(p); n != 0 || err != io.EOF {
+		t.Errorf("read %d bytes, %v at the end, want %v", n, err, io.EOF)
+	}
+
+	// The error of the stream is returned after its data
+	b = newRing(100)
+	streamErr := errors.New("connection reset")
+	b.ReadFrom(io.MultiReader(bytes.NewReader(sequence(0, 10)), &errReader{streamErr}))
+	if err := b.fill(50); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := b.Read(p); n != 10 || err != nil {
+		t.Errorf("read %d bytes, %v, want 10 bytes", n, err)
+	}
+	if _, err := b.Read(p); err != streamErr {
+		t.Errorf("read error %v, want %v", err, streamErr)
+	}
+
+	// A threshold above the size waits for a full ring
+	b = newRing(100)
+	go b.Write(sequence(0, 150))
+	if err := b.fill(1000); err != nil || b.Buffered() != 100 {
+		t.Errorf("filled %d bytes, %v, want 100", b.Buffered(), err)
+	}
+
+	// And returns when the ring is closed
+	b = newRing(100)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		b.close()
+	}()
+	if err := b.fill(50); err != errRingClosed {
+		t.Errorf("fill of closed ring: error %v, want %v", err, errRingClosed)
+	}
+}
+
+type errReader struct{ err error }
+
+func (r *errReader) Read([]byte) (int, error) { return 0, r.err }
+
+func TestRingCloseBlocked(t *testing.T) {
+	b := newRing(10)
+	written := make(chan int)
+	go func() {
+		n, err := b.Write(sequence(0, 100))
+		if err != errRingClosed {
+			t.Errorf("write to closed ring: error %v, want %v", err, errRingClosed)
+		}
+		written <- n
+	}()
+	waitFor(t, func() bool { return b.Buffered() == 10 })
+
+	// The writer waits for room, and a change of format for the reader
+	formatErr := make(chan error)
+	go func() {
+		formatErr <- b.setFormat(pcm.Format{SampleFormat: pcm.S16LE, Rate: 44100, Channels: 2})
+	}()
+
+	b.close()
+	if n := <-written; n != 10 {
+		t.Errorf("wrote %d bytes, want 10", n)
+	}
+	if err := <-formatErr; err != errRingClosed {
+		t.Errorf("format of closed ring: error %v, want %v", err, errRingClosed)
+	}
+	if b.Buffered() != 0 {
+		t.Errorf("%d bytes buffered after close", b.Buffered())
+	}
+	if _, err := b.Read(make([]byte, 10)); err != errRingClosed {
+		t.Errorf("read of closed ring: error %v, want %v", err, errRingClosed)
+	}
+	if _, err := b.Write([]byte{1}); err != errRingClosed {
+		t.Errorf("write to closed ring: error %v, want %v", err, errRingClosed)
+	}
+}
+
+func TestRingResetBlocked(t *testing.T) {
+	b := newRing(10)
+	data := sequence(0, 100)
+	written := make(chan error)
+	go func() {
+		_, err := b.Write(data)
+		b.closeWrite(io.EOF)
+		written <- err
+	}()
+	waitFor(t, func() bool { return b.Buffered() == 10 })
+
+	// The writer continues when the reader has seen the reset
+	b.reset()
+	got, err := io.ReadAll(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = <-written; err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data[10:]) {
+		t.Errorf("read %d bytes after reset, want the %d bytes written after it", len(got), len(data)-10)
+	}
+}
+
+func TestRingConcurrentReset(t *testing.T) {
+	b := newRing(997)
+	stop := make(chan struct{})
+	go func() {
+		for n := 0; ; n += 1000 {
+			if _, err := b.Write(sequence(n, 1000)); err != nil {
+				return
+			}
+		}
+	}()
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				b.reset()
+				time.Sleep(10 * time.Microsecond)
+			}
+		}
+	}()
+
+	// A reset drops data, but never tears a read
+	p := make([]byte, 1500)
+	for i := 0; i < 20000; i++ {
+		n, err := b.Read(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !contiguous(p[:n]) {
+			t.Fatalf("read %d bytes out of sequence", n)
+		}
+	}
+	close(stop)
+	b.close()
+}
@@ -0,0 +1,279 @@
+/*
+ *  (c) 2012 Bart Lauret
+ *
+ *  This file is part of slimgo.
+ *
+ *  slimgo is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  slimgo is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with slimgo.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package player
+
+import (
+	"github.com/terual/slimgo/pcm"
+	"github.com/terual/slimgo/slimproto"
+)
+
+// outBufSize is the most written to the output at once
+const outBufSize = 32 * 1024
+
+// Close the output
+func (p *Player) slimaudioClose() (err error) {
+	err = p.audio.Output.Close()
+	if p.config.Debug {
+		p.log.Println("Output closed")
+	}
+	return
+}
+
+// Writes data to the output
+func (p *Player) slimaudioWrite(nStart int, nEnd int, data []byte, format pcm.Format) (n int, outputErr error, writeErr error) {
+
+	p.audio.mu.Lock()
+	opened := p.audio.Format == format
+	p.audio.mu.Unlock()
+	if !opened || !format.Valid() {
+
+		outputErr = p.audio.Output.Open(format) // This also drains the output buffer
+
+		if outputErr != nil {
+			p.log.Printf("Set params error: %s", outputErr)
+			return 0, outputErr, nil
+		} else {
+			p.audio.mu.Lock()
+			p.audio.Format = format
+			p.audio.mu.Unlock()
+			if p.config.Debug {
+				p.log.Println("Output set to", format)
+			}
+		}
+	}
+
+	delayFrames, _ := p.audio.Output.Delay()
+
+	// Skip frames after a strm a, by not writing them
+	p.audio.mu.Lock()
+	if p.audio.Skip > 0 && nEnd > nStart {
+		n = p.audio.Skip * format.FrameSize()
+		if n > nEnd-nStart {
+			n = (nEnd - nStart) / format.FrameSize() * format.FrameSize()
+		}
+		p.audio.Skip -= n / format.FrameSize()
+		p.audio.mu.Unlock()
+		return n, nil, nil
+	}
+
+	framesWritten := p.audio.FramesWritten
+	newTrack := p.audio.NewTrack && framesWritten >= delayFrames
+	if newTrack {
+		p.audio.NewTrack = false
+	}
+	p.audio.mu.Unlock()
+	if newTrack {
+		p.log.Printf("NEW TRACK? FramesWritten: %v, delayFrames: %v", framesWritten, delayFrames)
+		_ = p.slimprotoSend(0, "STMs") // Track Started
+	}
+
+	if nEnd > nStart {
+		n, writeErr = p.audio.Output.Write(data[nStart:nEnd])
+
+		if writeErr != nil {
+			p.log.Printf("Write failed. %s\n", writeErr)
+
+			// Stop write if state is stopped
+			if p.audio.state() == "STOPPED" {
+				return n, nil, nil
+			}
+		}
+
+		if n > 0 {
+			p.audio.mu.Lock()
+			p.audio.FramesWritten += n / format.FrameSize()
+			p.audio.mu.Unlock()
+		}
+
+	} else {
+		return 0, nil, nil
+	}
+
+	return n, nil, writeErr
+}
+
+// slimaudioPlay is the output goroutine of s, it plays the decoded audio of s
+// after the stream before it is played
+func (p *Player) slimaudioPlay(s *stream, prev <-chan struct{}) {
+	defer close(s.played)
+	if prev != nil {
+		<-prev
+	}
+	select {
+	case <-s.start:
+	case <-s.out.closed():
+		return
+	}
+	p.buffer.Output.Store(s.out)
+
+	// This tracks the streamtime
+	p.audio.mu.Lock()
+	if p.audio.FramesWritten > 0 {
+		p.audio.LastFramesWritten = p.audio.FramesWritten
+	}
+	p.audio.FramesWritten = 0
+	p.audio.NewTrack = true
+	p.audio.mu.Unlock()
+
+	buf := make([]byte, outBufSize)
+	for {
+		// wait for slimproto before carrying on
+		if resume := p.audio.paused(); resume != nil {
+			select {
+			case <-resume:
+			case <-s.out.closed():
+			}
+		}
+
+		n, format, err := s.out.ReadFrames(buf)
+
+		// Once per buffer, the writes below may take several tries
+		p.audio.Volume.Apply(buf[:n], format)
+		for nStart := 0; nStart < n; {
+			nOut, outputErr, writeErr := p.slimaudioWrite(nStart, n, buf, format)
+
+			// An outputErr is raised if for instance S24_3LE is not supported by hw:0,0
+			if outputErr != nil {
+				p.log.Printf("Format not supported, if using hw as output device, try plughw: %v", outputErr)
+				_ = p.slimprotoSendError(slimproto.ErrorOutput)
+				p.audio.mu.Lock()
+				p.audio.Format = pcm.Format{}
+				p.audio.setStateLocked("STOPPED")
+				p.audio.mu.Unlock()
+				p.slimbufferStop()
+				return
+			}
+
+			// Reset the output, the rest of the frames is lost
+			if writeErr != nil {
+				_ = p.audio.Output.Drop()
+				break
+			}
+			if nOut == 0 {
+				break
+			}
+			nStart += nOut
+		}
+
+		if err == errRingClosed {
+			if p.config.Debug {
+				p.log.Println("Stopping output goroutine")
+			}
+			return
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	// The buffer ran empty, which ends the playback unless the server
+	// has sent the next stream
+	if p.buffer.Stream.Load() == s {
+		// STMu is sent once the device has played the last frames
+		if err := p.audio.Output.Drain(); err != nil {
+			p.log.Printf("Output drain failed. %s", err)
+		}
+		p.audio.setState("STOPPED")
+		_ = p.slimprotoSend(0, "STMu")
+	}
+}
+
+// slimaudioElapsedFrames returns the frames played of the frames written,
+// which are counted from the start of the current and the last track
+func (p *Player) slimaudioElapsedFrames(framesWritten int, lastFramesWritten int) (elapsedFrames int, err error) {
+
+	delayFrames, err := p.audio.Output.Delay()
+	if err == nil {
+		elapsedFrames = framesWritten - delayFrames
+		if elapsedFrames < 0 {
+			return (lastFramesWritten + elapsedFrames), nil
+		}
+		return elapsedFrames, nil
+	}
+	return 0, err
+}
+
+// Convert slimproto format to a PCM format
+func slimaudioProto2Param(pcmsamplesize uint8, pcmsamplerate uint8, pcmchannels uint8, pcmendian uint8) (format pcm.Format) {
+
+	switch pcmchannels {
+	case 49:
+		format.Channels = 1
+	case 50:
+		format.Channels = 2
+	}
+
+	switch pcmsamplerate {
+	case 48: //0
+		format.Rate = 11025
+	case 49: //1
+		format.Rate = 22050
+	case 50: //2
+		format.Rate = 32000
+	case 51: //3
+		format.Rate = 44100
+	case 52: //4
+		format.Rate = 48000
+	case 53: //5
+		format.Rate = 8000
+	case 54: //6
+		format.Rate = 12000
+	case 55: //7
+		format.Rate = 16000
+	case 56: //8
+		format.Rate = 24000
+	case 57: //9
+		format.Rate = 96000
+	case 58: //:
+		format.Rate = 88200
+	case 59: //;
+		format.Rate = 192000
+	case 60: //<
+		format.Rate = 176400
+	}
+
+	switch pcmendian {
+	case 48:
+		// 0: big-endian
+		switch pcmsamplesize {
+		case 48: //0: 8-bits
+			format.SampleFormat = pcm.S8
+		case 49: //1: 16-bits
+			format.SampleFormat = pcm.S16BE
+		case 50: //2: 24-bits
+			format.SampleFormat = pcm.S24_3BE
+		case 51: //3: 32-bits
+			format.SampleFormat = pcm.S32BE
+		}
+	case 49:
+		// 1: little-endian
+		switch pcmsamplesize {
+		case 48: //0: 8-bits
+			format.SampleFormat = pcm.S8
+		case 49: //1: 16-bits
+			format.SampleFormat = pcm.S16LE
+		case 50: //2: 24-bits
+			format.SampleFormat = pcm.S24_3LE
+		case 51: //3: 32-bits
+			format.SampleFormat = pcm.S32LE
+		}
+	}
+
+	return
+}
@@ -0,0 +1,160 @@
+/*
+ *  (c) 2012 Bart Lauret
+ *
+ *  This file is part of slimgo.
+ *
+ *  slimgo is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  slimgo is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with slimgo.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package player
+
+import (
+	"bytes"
+	"github.com/terual/slimgo/pcm"
+	"io"
+	"sync"
+	"testing"
+)
+
+// testOutput records the audio written to it, taking at most maxFrames
+// frames per Write
+type testOutput struct {
+	mu        sync.Mutex
+	format    pcm.Format
+	data      []byte
+	maxFrames int
+	paused    bool
+	drained   int // the bytes written when it was last drained
+}
+
+func (o *testOutput) Open(format pcm.Format) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.format = format
+	return nil
+}
+
+func (o *testOutput) Write(data []byte) (n int, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	n = len(data) / o.format.FrameSize() * o.format.FrameSize()
+	if o.maxFrames > 0 && n > o.maxFrames*o.format.FrameSize() {
+		n = o.maxFrames * o.format.FrameSize()
+	}
+	o.data = append(o.data, data[:n]...)
+	return n, nil
+}
+
+func (o *testOutput) written() []byte {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]byte(nil), o.data...)
+}
+
+func (o *testOutput) Delay() (int, error) { return 0, nil }
+func (o *testOutput) BufferSize() int     { return 0 }
+func (o *testOutput) Drop() error         { return nil }
+func (o *testOutput) MaxSampleRate() int  { return 192000 }
+func (o *testOutput) Close() error        { return nil }
+
+func (o *testOutput) Drain() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.drained = len(o.data)
+	return nil
+}
+
+func (o *testOutput) Pause() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.paused = true
+	return nil
+}
+
+func (o *testOutput) Unpause() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.paused = false
+	return nil
+}
+
+// newTestPlayer returns a player without a server which plays to out
+func newTestPlayer(t *testing.T, out *testOutput) *Player {
+	t.Helper()
+	p, err := New(Config{Output: out, StreamBufSize: 1 << 16, OutputBufSize: 1 << 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.log.SetOutput(io.Discard)
+	return p
+}
+
+// newTestStream returns a stream of which the output is being played
+func newTestStream(p *Player) *stream {
+	s := &stream{
+		in:     newRing(p.config.StreamBufSize),
+		out:    newRing(p.config.OutputBufSize),
+		start:  make(chan struct{}),
+		played: make(chan struct{}),
+	}
+	prev := p.buffer.Stream.Swap(s)
+	var played <-chan struct{}
+	if prev != nil {
+		played = prev.played
+	}
+	go p.slimaudioPlay(s, played)
+	return s
+}
+
+func TestVolumeShortWrites(t *testing.T) {
+	out := &testOutput{maxFrames: 3}
+	p := newTestPlayer(t, out)
+	p.audio.Volume.Set(pcm.Unity/2, pcm.Unity/3)
+
+	format := pcm.Format{SampleFormat: pcm.S16LE, Rate: 44100, Channels: 2}
+	data := bytes.Repeat([]byte{0x00, 0x40}, 2*5000)
+	want := append([]byte(nil), data...)
+	volume := pcm.NewVolume()
+	volume.Set(pcm.Unity/2, pcm.Unity/3)
+	volume.Apply(want, format)
+
+	s := newTestStream(p)
+	s.out.setFormat(format)
+	s.out.Write(data)
+	s.out.closeWrite(io.EOF)
+	p.slimbufferPlay(s)
+	<-s.played
+
+	// Every frame has the gain applied once, however often it was
+	// written
+	if got := out.written(); !bytes.Equal(got, want) {
+		t.Errorf("wrote %d bytes different from the %d bytes with the gain applied once", len(got), len(want))
+	}
+}
+
+func TestDrainBeforeUnderrun(t *testing.T) {
+	out := new(testOutput)
+	p := newTestPlayer(t, out)
+	srv := newTestServer(t, p)
+
+	data := bytes.Repeat([]byte{1}, 4*1000)
+	playTestStream(p, data)
+	srv.wait("STMu")
+	out.mu.Lock()
+	drained := out.drained
+	out.mu.Unlock()
+	if drained != len(data) {
+		t.Errorf("drained after %d bytes before STMu, want %d", drained, len(data))
+	}
+}
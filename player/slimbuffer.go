@@ -0,0 +1,413 @@
+/*
+ *  (c) 2012 Bart Lauret
+ *
+ *  This file is part of slimgo.
+ *
+ *  slimgo is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  slimgo is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with slimgo.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package player
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"github.com/terual/slimgo/decoder"
+	"github.com/terual/slimgo/slimproto"
+	"io"
+	"net"
+	"net/http/httputil"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// inBufSize is the size of the buffer between the decoder and the output
+// buffer
+const inBufSize = 32 * 1024
+
+// maxHeaderSize is the largest response header of a stream
+const maxHeaderSize = 64 * 1024
+
+// errHeaderTooLarge is returned for a response header of over maxHeaderSize
+var errHeaderTooLarge = errors.New("response header too large")
+
+// httpResponse is the response to the HTTP header of a strm
+type httpResponse struct {
+	Status int
+	Header textproto.MIMEHeader
+	Raw    []byte    // the header as it was received
+	Body   io.Reader // the rest of the connection
+}
+
+// slimbufferConnect sends the HTTP header of strm as it is to addr, like a
+// Squeezebox does, and reads the response header. It may be HTTP or ICY. With
+// a tlsConfig the connection is HTTPS, the certificate is checked against the
+// Host in the header.
+func slimbufferConnect(addr string, header []byte, tlsConfig *tls.Config) (conn net.Conn, resp *httpResponse, err error) {
+	conn, err = net.DialTimeout("tcp", addr, dialTimeout)
+	if err != nil {
+		return nil, nil, err
+	}
+	if tlsConfig != nil {
+		if tlsConfig.ServerName == "" {
+			tlsConfig = tlsConfig.Clone()
+			tlsConfig.ServerName = headerHost(header)
+		}
+		tlsConn := tls.Client(conn, tlsConfig)
+		tlsConn.SetDeadline(time.Now().Add(dialTimeout))
+		if err = tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+		tlsConn.SetDeadline(time.Time{})
+		conn = tlsConn
+	}
+	if _, err = conn.Write(header); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	resp = new(httpResponse)
+	for partial := false; ; {
+		line, err := br.ReadSlice('\n')
+		resp.Raw = append(resp.Raw, line...)
+		if len(resp.Raw) > maxHeaderSize {
+			conn.Close()
+			return nil, nil, errHeaderTooLarge
+		}
+		if err == bufio.ErrBufferFull {
+			// A line longer than the buffer
+			partial = true
+			continue
+		}
+		if err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+		if !partial && len(bytes.TrimSpace(line)) == 0 {
+			break
+		}
+		partial = false
+	}
+
+	tp := textproto.NewReader(bufio.NewReader(bytes.NewReader(resp.Raw)))
+	status, _ := tp.ReadLine()
+	fields := strings.Fields(status)
+	if len(fields) < 2 {
+		conn.Close()
+		return nil, nil, errors.New("malformed status line: " + status)
+	}
+	if resp.Status, err = strconv.Atoi(fields[1]); err != nil {
+		conn.Close()
+		return nil, nil, errors.New("malformed status line: " + status)
+	}
+	if resp.Header, err = tp.ReadMIMEHeader(); err != nil && err != io.EOF {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	resp.Body = br
+	if strings.EqualFold(resp.Header.Get("Transfer-Encoding"), "chunked") {
+		resp.Body = httputil.NewChunkedReader(br)
+	}
+	return conn, resp, nil
+}
+
+// stream is a stream of the server on its way to the output. The network
+// goroutine fills in, the decoder goroutine reads in and fills out, and the
+// output goroutine plays out once the previous stream is played and the
+// stream is started.
+type stream struct {
+	in     *ring
+	out    *ring
+	start  chan struct{} // closed when the output may start
+	once   sync.Once
+	played chan struct{} // closed when out is played or closed
+}
+
+// started reports whether the output of the stream may start
+func (s *stream) started() bool {
+	select {
+	case <-s.start:
+		return true
+	default:
+		return false
+	}
+}
+
+// close stops the stream and discards its buffers
+func (s *stream) close() {
+	s.in.close()
+	s.out.close()
+}
+
+// stopped reports whether the stream is closed
+func (s *stream) stopped() bool {
+	select {
+	case <-s.out.closed():
+		return true
+	default:
+		return false
+	}
+}
+
+// slimbufferPlay starts the output of s, after the stream before it
+func (p *Player) slimbufferPlay(s *stream) {
+	s.once.Do(func() {
+		close(s.start)
+	})
+	p.audio.setState("PLAYING", "BUFFERING")
+}
+
+// slimbufferStop stops the stream being received and the audio being played
+func (p *Player) slimbufferStop() {
+	p.buffer.Init.Store(false)
+	if s := p.buffer.Stream.Load(); s != nil {
+		s.close()
+	}
+	if out := p.buffer.Output.Load(); out != nil {
+		out.close()
+	}
+}
+
+// headerHost returns the host name of the Host field of an HTTP header
+func headerHost(header []byte) string {
+	tp := textproto.NewReader(bufio.NewReader(bytes.NewReader(header)))
+	if _, err := tp.ReadLine(); err != nil {
+		return ""
+	}
+	fields, _ := tp.ReadMIMEHeader()
+	host := fields.Get("Host")
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.Trim(host, "[]")
+}
+
+func (p *Player) slimbufferOpen(strm *slimproto.Strm, addr string, port string) (err error) {
+
+	s := &stream{
+		in:     newRing(p.config.StreamBufSize),
+		out:    newRing(p.config.OutputBufSize),
+		start:  make(chan struct{}),
+		played: make(chan struct{}),
+	}
+	// A stream which was never started is replaced
+	var prev <-chan struct{}
+	if old := p.buffer.Stream.Swap(s); old != nil {
+		if !old.started() {
+			old.close()
+		}
+		prev = old.played
+	}
+	p.buffer.Init.Store(false)
+	p.buffer.BytesReceived.Store(0)
+
+	// The output is played after the previous stream, also when this
+	// stream fails
+	go p.slimaudioPlay(s, prev)
+
+	var tlsConfig *tls.Config
+	if strm.Flags&slimproto.StrmFlagTLS != 0 {
+		tlsConfig = p.config.TLS
+		if tlsConfig == nil {
+			tlsConfig = new(tls.Config)
+		}
+	}
+	conn, r, err := slimbufferConnect(net.JoinHostPort(addr, port), strm.HTTPHeader, tlsConfig)
+	if err != nil {
+		p.log.Printf("Cannot open stream: %v", err)
+		_ = p.slimprotoSendError(slimproto.ErrorStream)
+		s.close()
+		return
+	}
+
+	// Stopping the stream closes the connection
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-s.in.closed():
+		case <-done:
+		}
+		conn.Close()
+	}()
+
+	_ = p.slimprotoSend(0, "STMe") // Stream connection Established
+
+	// The server reads the content type, the interval of the shoutcast
+	// metadata and redirects from the response header as it was received
+	_ = p.slimprotoWrite(&slimproto.RESP{Header: r.Raw})
+	_ = p.slimprotoSend(0, "STMh") // Headers received
+
+	switch {
+	case r.Status == 200, r.Status == 206: // OK, or Partial Content for a Range
+	case r.Status >= 300 && r.Status < 400:
+		// The server follows the redirect with a new strm
+		if p.config.Debug {
+			p.log.Printf("Stream redirected to %s", r.Header.Get("Location"))
+		}
+		s.close()
+		return
+	default:
+		p.log.Printf("Stream refused with status %v", r.Status)
+		_ = p.slimprotoSendError(slimproto.ErrorStream)
+		s.close()
+		return
+	}
+
+	// The shoutcast metadata of a radio stream is taken out of the audio
+	// and sent to the server
+	p.buffer.Title.Store(new(string))
+	body := r.Body
+	if metaint, _ := strconv.Atoi(r.Header.Get("Icy-Metaint")); metaint > 0 {
+		body = newICYReader(body, metaint, p.slimbufferMeta)
+	}
+
+	// The stream is read into the ring by its own goroutine, until the
+	// end of the stream or until the ring is closed
+	go s.in.ReadFrom(byteCounter{body, &p.buffer.BytesReceived})
+
+	// Buffer the threshold of the server, then the output is started right
+	// away with autostart, or else by strm u, e.g. to start a sync group
+	// at once
+	if err = s.in.fill(int(strm.Threshold) * 1024); err != nil {
+		return nil
+	}
+	p.buffer.Init.Store(true)
+	_ = p.slimprotoSend(0, "STMl") // Buffer threshold reached
+	if strm.Autostart == '1' || strm.Autostart == '3' {
+		p.slimbufferPlay(s)
+	}
+
+	format := slimaudioProto2Param(strm.Pcmsamplesize,
+		strm.Pcmsamplerate,
+		strm.Pcmchannels,
+		strm.Pcmendian)
+
+	// Compressed streams are decoded to PCM, a PCM stream may start
+	// with a WAV or AIFF header
+	codec, ok := decoder.Lookup(strm.Formatbyte)
+	if !ok {
+		p.log.Printf("Format not supported, Formatbyte: %c", strm.Formatbyte)
+		_ = p.slimprotoSendError(slimproto.ErrorUnsupportedFormat)
+		s.close()
+		return
+	}
+	var src decoder.Decoder
+	if src, err = codec.New(s.in, format); err != nil {
+		if !s.stopped() {
+			p.log.Printf("Cannot decode stream: %v", err)
+			_ = p.slimprotoSendError(slimproto.ErrorUnsupportedFormat)
+		}
+		s.close()
+		return err
+	}
+	if c, ok := src.(io.Closer); ok {
+		defer c.Close()
+	}
+	format = src.Format()
+
+	if !format.Valid() {
+		p.log.Printf("Cannot play stream with format %v", format)
+		_ = p.slimprotoSendError(slimproto.ErrorUnsupportedFormat)
+		s.close()
+		return
+	}
+	if p.config.Debug {
+		p.log.Printf("Playing %c stream as %v", strm.Formatbyte, format)
+	}
+	_ = s.out.setFormat(format)
+
+	frameSize := format.FrameSize()
+	inBuf := make([]byte, inBufSize)
+
+	n, inErr := src.Read(inBuf)
+
+	for {
+		// Only whole frames are buffered, the rest is kept for the
+		// next read
+		frames := n / frameSize * frameSize
+		if _, err = s.out.Write(inBuf[:frames]); err != nil {
+			// Stopped
+			return nil
+		}
+		n = copy(inBuf, inBuf[frames:n])
+		if inErr != nil {
+			break
+		}
+
+		// Read when the rest is buffered, so that a change of format
+		// of a decoder never mixes two formats
+		var m int
+		m, inErr = src.Read(inBuf[n:])
+		n += m
+		if src.Format() != format {
+			format = src.Format()
+			frameSize = format.FrameSize()
+			if p.config.Debug {
+				p.log.Printf("Stream changed to %v", format)
+			}
+			if err = s.out.setFormat(format); err != nil {
+				return nil
+			}
+		}
+	}
+
+	// The output plays the rest of the buffer
+	s.out.closeWrite(io.EOF)
+
+	if s.stopped() {
+		return nil
+	}
+	if inErr != io.EOF {
+		p.log.Printf("Stream failed: %v", inErr)
+		_ = p.slimprotoSendError(slimproto.ErrorStream)
+		return inErr
+	}
+
+	// STMd triggers the switch in the server to the next track, which is
+	// played after the rest of this one
+	return p.slimprotoSend(0, "STMd")
+}
+
+// slimbufferMeta sends a block of shoutcast metadata to the server, which
+// shows the title of the stream
+func (p *Player) slimbufferMeta(data []byte) {
+	fields := parseICY(string(data))
+	if title, ok := fields["StreamTitle"]; ok {
+		p.buffer.Title.Store(&title)
+		if p.config.Debug {
+			p.log.Printf("Stream title: %s, url: %s", title, fields["StreamUrl"])
+		}
+	}
+	_ = p.slimprotoWrite(&slimproto.META{Data: data})
+}
+
+// byteCounter counts the bytes read from a stream
+type byteCounter struct {
+	r io.Reader
+	n *atomic.Uint64
+}
+
+func (c byteCounter) Read(b []byte) (n int, err error) {
+	n, err = c.r.Read(b)
+	c.n.Add(uint64(n))
+	return
+}
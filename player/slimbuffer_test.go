@@ -0,0 +1,379 @@
+/*
+ *  (c) 2012 Bart Lauret
+ *
+ *  This file is part of slimgo.
+ *
+ *  slimgo is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  slimgo is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with slimgo.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package player
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"github.com/terual/slimgo/slimproto"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serveOnce serves a single connection on a local port, it reads a request
+// of size bytes, which is sent to request, and replies with response
+func serveOnce(t *testing.T, size int, response string) (addr net.Addr, request <-chan []byte) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	c := make(chan []byte, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf := make([]byte, size)
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return
+		}
+		c <- buf
+		io.WriteString(conn, response)
+	}()
+	return l.Addr(), c
+}
+
+// testStrm returns a strm s of a 16 bits stereo PCM stream from addr
+func testStrm(addr net.Addr, header string) *slimproto.Strm {
+	tcp := addr.(*net.TCPAddr)
+	return &slimproto.Strm{
+		Command:       's',
+		Autostart:     '1',
+		Formatbyte:    'p',
+		Pcmsamplesize: '1',
+		Pcmsamplerate: '3',
+		Pcmchannels:   '2',
+		Pcmendian:     '1',
+		Server_port:   uint16(tcp.Port),
+		Server_ip:     [4]byte(tcp.IP.To4()),
+		HTTPHeader:    []byte(header),
+	}
+}
+
+// TestStrmThreshold checks that a stream is only started once the threshold
+// is buffered, right away with autostart or else by strm u
+func TestStrmThreshold(t *testing.T) {
+	tests := []struct {
+		autostart byte
+		started   bool
+	}{
+		{'0', false},
+		{'1', true},
+		{'2', false},
+		{'3', true},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.autostart), func(t *testing.T) {
+			data := sequence(0, 4*1024)
+			more := make(chan struct{})
+			web := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write(data[:1024])
+				w.(http.Flusher).Flush()
+				<-more
+				w.Write(data[1024:])
+			}))
+			defer web.Close()
+			defer close(more)
+
+			out := new(testOutput)
+			p := newTestPlayer(t, out)
+			srv := newTestServer(t, p)
+
+			strm := testStrm(web.Listener.Addr(), "GET /stream HTTP/1.0\r\n\r\n")
+			strm.Autostart = tt.autostart
+			strm.Threshold = 2 // KB
+			srv.send(strm)
+			srv.wait("STMh")
+			s := p.buffer.Stream.Load()
+
+			// Half of the threshold is buffered
+			select {
+			case event := <-srv.events:
+				t.Fatalf("%s before the threshold", event)
+			case <-time.After(50 * time.Millisecond):
+			}
+			if p.buffer.Init.Load() {
+				t.Error("Init set before the threshold")
+			}
+
+			more <- struct{}{}
+			srv.wait("STMl")
+			if !p.buffer.Init.Load() {
+				t.Error("Init not set at the threshold")
+			}
+			srv.wait("STMd")
+			if tt.started {
+				waitPlayed(t, s)
+			} else {
+				time.Sleep(50 * time.Millisecond)
+				if s.started() || len(out.written()) != 0 {
+					t.Fatal("started without autostart")
+				}
+				srv.send(&slimproto.Strm{Command: 'u'})
+				srv.wait("STMr")
+				waitPlayed(t, s)
+			}
+			if got := out.written(); !bytes.Equal(got, data) {
+				t.Errorf("wrote %d bytes, want %d", len(got), len(data))
+			}
+
+			srv.send(&slimproto.Strm{Command: 'q'})
+			srv.wait("STMf")
+			if p.buffer.Init.Load() {
+				t.Error("Init set after strm q")
+			}
+		})
+	}
+}
+
+// TestStrmServerIP checks that a stream is received from the host in the
+// strm, or else from the server
+func TestStrmServerIP(t *testing.T) {
+	tests := []struct {
+		name   string
+		server net.IP // of slimproto
+		direct bool   // the strm has the host of the stream
+		status int
+		want   string
+	}{
+		{"server", net.IPv4(127, 0, 0, 1), false, 200, "STMd"},
+		{"direct", net.IPv4(127, 0, 0, 2), true, 200, "STMd"},
+		{"refused", net.IPv4(127, 0, 0, 1), false, 404, "STMn"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := sequence(0, 4*1024)
+			web := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write(data)
+			}))
+			defer web.Close()
+
+			out := new(testOutput)
+			p := newTestPlayer(t, out)
+			srv := newTestServer(t, p)
+			p.server.Addr = tt.server
+
+			strm := testStrm(web.Listener.Addr(), "GET /stream HTTP/1.0\r\n\r\n")
+			if !tt.direct {
+				strm.Server_ip = [4]byte{}
+			}
+			srv.send(strm)
+			srv.wait(tt.want)
+			if tt.want != "STMd" {
+				return
+			}
+			waitPlayed(t, p.buffer.Stream.Load())
+			if got := out.written(); !bytes.Equal(got, data) {
+				t.Errorf("wrote %d bytes, want %d", len(got), len(data))
+			}
+		})
+	}
+}
+
+// TestStrmRESP checks that the response header is sent to the server as it
+// was received, between STMe and STMh
+func TestStrmRESP(t *testing.T) {
+	tests := []struct {
+		name      string
+		header    string // of the response
+		autostart byte
+	}{
+		{"stream", "HTTP/1.0 200 OK\r\nContent-Type: audio/L16\r\nX-Odd:  spaces \r\n\r\n", '1'},
+		{"direct", "ICY 200 OK\r\nicy-name: radio\r\n\r\n", '3'},
+		{"redirect", "HTTP/1.1 302 Found\r\nLocation: http://radio/\r\n\r\n", '1'},
+	}
+	request := "GET /stream HTTP/1.0\r\n\r\n"
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, _ := serveOnce(t, len(request), tt.header+string(sequence(0, 1024)))
+			p := newTestPlayer(t, new(testOutput))
+			srv := newTestServer(t, p)
+
+			strm := testStrm(addr, request)
+			strm.Autostart = tt.autostart
+			srv.send(strm)
+			events := srv.wait("STMh")
+			if want := []string{"STMc", "STMe", "RESP"}; !slices.Equal(events, want) {
+				t.Errorf("events %v before STMh, want %v", events, want)
+			}
+			if header := <-srv.data; string(header) != tt.header {
+				t.Errorf("RESP %q, want %q", header, tt.header)
+			}
+		})
+	}
+}
+
+func TestSlimbufferConnect(t *testing.T) {
+	long := "X-Long: " + strings.Repeat("a", 8*1024) + "\r\n"
+	tests := []struct {
+		name   string
+		header string // of the response
+		rest   string
+		status int
+		key    string // of a field in the header
+		value  string
+		body   string
+		err    error // nil for any error with status 0
+	}{
+		{"http", "HTTP/1.0 200 OK\r\nContent-Type: audio/mpeg\r\n\r\n", "audio",
+			200, "Content-Type", "audio/mpeg", "audio", nil},
+		{"icy", "ICY 200 OK\r\nicy-metaint: 8192\r\n\r\n", "audio",
+			200, "Icy-Metaint", "8192", "audio", nil},
+		{"redirect", "HTTP/1.1 302 Found\r\nLocation: http://radio/\r\n\r\n", "",
+			302, "Location", "http://radio/", "", nil},
+		{"chunked", "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n", "3\r\naud\r\n2\r\nio\r\n0\r\n\r\n",
+			200, "Transfer-Encoding", "chunked", "audio", nil},
+		{"long line", "HTTP/1.0 200 OK\r\n" + long + "\r\n", "audio",
+			200, "X-Long", strings.Repeat("a", 8*1024), "audio", nil},
+		{"bare LF", "HTTP/1.0 200 OK\nContent-Type: audio/mpeg\n\n", "audio",
+			200, "Content-Type", "audio/mpeg", "audio", nil},
+		{"too large", "HTTP/1.0 200 OK\r\n" + strings.Repeat(long, 8) + "\r\n", "",
+			0, "", "", "", errHeaderTooLarge},
+		{"no status", "200\r\n\r\n", "", 0, "", "", "", nil},
+		{"malformed status", "HTTP/1.0 OK\r\n\r\n", "", 0, "", "", "", nil},
+		{"closed", "HTTP/1.0 200 OK\r\n", "", 0, "", "", "", nil},
+	}
+	// Sent as it is, however odd
+	header := []byte("GET /stream.mp3?player=00:04:20:12:34:56 HTTP/1.0\r\nHost: radio\r\nIcy-MetaData: 1\r\nx-odd:  spaces \r\n\r\n")
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, request := serveOnce(t, len(header), tt.header+tt.rest)
+			conn, r, err := slimbufferConnect(addr.String(), header, nil)
+			if got := <-request; !bytes.Equal(got, header) {
+				t.Errorf("sent header %q, want %q", got, header)
+			}
+			if tt.status == 0 {
+				if err == nil {
+					conn.Close()
+					t.Fatal("no error")
+				}
+				if tt.err != nil && !errors.Is(err, tt.err) {
+					t.Errorf("error %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			if r.Status != tt.status {
+				t.Errorf("status %d, want %d", r.Status, tt.status)
+			}
+			if value := r.Header.Get(tt.key); value != tt.value {
+				t.Errorf("%s %q, want %q", tt.key, value, tt.value)
+			}
+			if string(r.Raw) != tt.header {
+				t.Errorf("raw header %q, want %q", r.Raw, tt.header)
+			}
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != tt.body {
+				t.Errorf("body %q, want %q", body, tt.body)
+			}
+		})
+	}
+}
+
+// TestSlimbufferConnectTLS checks that the certificate of an HTTPS stream is
+// checked against the Host in the header
+func TestSlimbufferConnectTLS(t *testing.T) {
+	tests := []struct {
+		name       string
+		host       string // of the header
+		serverName string // of the config
+		ok         bool
+	}{
+		{"host", "example.com", "", true},
+		{"host and port", "example.com:443", "", true},
+		{"wrong host", "radio.invalid", "", false},
+		{"no host", "", "", false},
+		{"server name", "radio.invalid", "example.com", true},
+	}
+	web := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("audio"))
+	}))
+	defer web.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(web.Certificate())
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := "GET /stream HTTP/1.0\r\n"
+			if tt.host != "" {
+				header += "Host: " + tt.host + "\r\n"
+			}
+			header += "\r\n"
+			config := &tls.Config{RootCAs: roots, ServerName: tt.serverName}
+			conn, r, err := slimbufferConnect(web.Listener.Addr().String(), []byte(header), config)
+			if !tt.ok {
+				if err == nil {
+					conn.Close()
+					t.Fatal("connected with a certificate of another host")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			if r.Status != 200 {
+				t.Errorf("status %d, want 200", r.Status)
+			}
+			if config.ServerName != tt.serverName {
+				t.Errorf("ServerName of the config changed to %q", config.ServerName)
+			}
+		})
+	}
+}
+
+func TestHeaderHost(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"GET / HTTP/1.0\r\nHost: radio\r\n\r\n", "radio"},
+		{"GET / HTTP/1.0\r\nhost: radio:8000\r\n\r\n", "radio"},
+		{"GET / HTTP/1.0\r\nHost: [::1]:443\r\n\r\n", "::1"},
+		{"GET / HTTP/1.0\r\nHost: [::1]\r\n\r\n", "::1"},
+		{"GET / HTTP/1.0\r\nIcy-MetaData: 1\r\n\r\n", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if host := headerHost([]byte(tt.header)); host != tt.want {
+			t.Errorf("headerHost(%q) = %q, want %q", tt.header, host, tt.want)
+		}
+	}
+}
@@ -0,0 +1,490 @@
+/*
+ *  (c) 2012 Bart Lauret
+ *
+ *  This file is part of slimgo.
+ *
+ *  slimgo is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  slimgo is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with slimgo.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package player
+
+import (
+	"context"
+	"errors"
+	"github.com/terual/slimgo/decoder"
+	"github.com/terual/slimgo/pcm"
+	"github.com/terual/slimgo/slimproto"
+	"net"
+	"strconv"
+	"time"
+)
+
+// Connect to slimproto
+func (p *Player) slimprotoConnect(ctx context.Context, addr net.IP, port int) (err error) {
+
+	dialer := net.Dialer{Timeout: dialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(addr.String(), strconv.Itoa(port)))
+	if err != nil {
+		return err
+	}
+
+	p.server.mu.Lock()
+	p.server.Conn = conn
+	p.server.mu.Unlock()
+
+	if p.config.Debug {
+		p.log.Println("Connected to slimproto")
+	}
+
+	return
+
+}
+
+// errServerSwitch is returned by slimprotoRecv when the server told the
+// player to connect to another server
+var errServerSwitch = errors.New("switching to another server")
+
+// Receive from slimproto and act upon
+func (p *Player) slimprotoRecv() (errProto error) {
+
+	msg, errProto := slimproto.ReadMessage(p.server.Conn)
+	if errProto != nil {
+		return
+	}
+
+	switch response := msg.(type) {
+	case *slimproto.Strm:
+		if p.config.Debug {
+			p.log.Printf("[Recv strm] Command: %s, Autostart: %s, Formatbyte: %s, Pcmsamplesize: %s, Pcmsamplerate: %s, Pcmchannels: %s, Pcmendian: %s\n",
+				string(response.Command), string(response.Autostart), string(response.Formatbyte),
+				string(response.Pcmsamplesize), string(response.Pcmsamplerate),
+				string(response.Pcmchannels), string(response.Pcmendian))
+		}
+
+		switch string(response.Command) {
+		case "t":
+			_ = p.slimprotoSend(response.Replay_gain, "STMt")
+		case "s":
+			// A stream sent while playing is played after the
+			// current one
+			p.audio.setState("BUFFERING", "", "STOPPED")
+			_ = p.slimprotoSend(0, "STMc")
+		case "p":
+			// Pausing fails when the device is not playing
+			if err := p.audio.Output.Pause(); err != nil && p.config.Debug {
+				p.log.Printf("Output pause failed. %s", err)
+			}
+			p.audio.setState("PAUSED")
+			if response.Replay_gain == 0 {
+				_ = p.slimprotoSend(0, "STMp")
+			} else {
+				// if non-zero, an interval (ms) to pause for and then automatically resume
+				// no STMp & STMr status messages are sent in this case.
+				p.audio.resumeAfter(time.Duration(response.Replay_gain) * time.Millisecond)
+			}
+		case "u":
+			p.waitJiffies(response.Replay_gain)
+			resumed := false
+			if p.audio.state() == "PAUSED" {
+				if err := p.audio.Output.Unpause(); err != nil && p.config.Debug {
+					p.log.Printf("Output unpause failed. %s", err)
+				}
+
+				// Wakes the output goroutine if it is waiting
+				p.audio.setState("PLAYING")
+				resumed = true
+			}
+			// Start a stream buffered without autostart, also when it
+			// was paused before it started
+			if s := p.buffer.Stream.Load(); s != nil && !s.started() {
+				p.slimbufferPlay(s)
+				resumed = true
+			}
+			if resumed {
+				_ = p.slimprotoSend(0, "STMr")
+			}
+		case "q":
+			p.slimbufferStop()
+			_ = p.audio.Output.Pause()
+			err := p.audio.Output.Drop()
+			if err != nil {
+				p.log.Printf("Output drop failed. %s", err)
+			}
+			p.audio.mu.Lock()
+			p.audio.Format = pcm.Format{}
+			p.audio.setStateLocked("STOPPED")
+			p.audio.mu.Unlock()
+			_ = p.slimprotoSend(0, "STMf")
+		case "f":
+			//flush
+			p.slimbufferStop()
+			_ = p.audio.Output.Pause()
+			err := p.audio.Output.Drop()
+			if err != nil {
+				p.log.Printf("Output drop failed. %s", err)
+			}
+			p.audio.mu.Lock()
+			p.audio.Format = pcm.Format{}
+			p.audio.mu.Unlock()
+			_ = p.slimprotoSend(0, "STMf")
+		case "a":
+			//skip-ahead
+			// replay_gain field: if non-zero, an interval (ms) to skip over (not play).
+			// The frames are skipped by slimaudioWrite
+			p.audio.mu.Lock()
+			p.audio.Skip = int(response.Replay_gain) * p.audio.Format.Rate / 1000
+			skip := p.audio.Skip
+			p.audio.mu.Unlock()
+			if p.config.Debug {
+				p.log.Printf("Skipping %v frames, %v ms", skip, response.Replay_gain)
+			}
+
+		default:
+			if p.config.Debug {
+				p.log.Printf("Did not recognise strm message with cmd: %s", string(response.Command))
+			}
+		}
+
+		if p.config.Debug {
+			p.log.Printf("p.audio.State: %s\n", p.audio.state())
+		}
+
+		// check if a http header is sent
+		if len(response.HTTPHeader) > 0 {
+
+			// Check flags
+			/*switch response.Flags {
+			case 64: //0x40
+				// stream without restarting decoder
+				p.audio.NewTrack = false
+			default:
+				p.audio.NewTrack = true
+				p.log.Printf("Flag: %v", response.Flags)
+			}*/
+			if _, ok := decoder.Lookup(response.Formatbyte); ok {
+				port := strconv.Itoa(int(response.Server_port))
+
+				// The stream comes from the server, unless it asks
+				// to stream directly from another host, e.g. a radio
+				// station
+				p.server.mu.Lock()
+				addr := p.server.Addr.String()
+				p.server.mu.Unlock()
+				if ip := response.Server_ip; ip != [4]byte{} {
+					addr = net.IP(ip[:]).String()
+				}
+
+				go p.slimbufferOpen(response, addr, port)
+			} else {
+				if p.config.Debug {
+					p.log.Printf("Format not supported, Formatbyte: %s", string(response.Formatbyte))
+				}
+				_ = p.slimprotoSendError(slimproto.ErrorUnsupportedFormat)
+			}
+		}
+
+	case *slimproto.Audg:
+		if p.config.Debug {
+			p.log.Printf("audioGainResponse, Old_left: %v, Old_right: %v, Dvc: %v, New_left: %v, New_right: %v",
+				response.Old_left, response.Old_right, response.Dvc,
+				response.New_left, response.New_right)
+		}
+
+		// Without digital volume control the output level is fixed
+		left, right := response.New_left, response.New_right
+		if response.Dvc == 0 {
+			left, right = pcm.Unity, pcm.Unity
+		}
+		if p.audio.Mixer != nil {
+			err := p.audio.Mixer.SetVolume(left, right)
+			if err != nil {
+				p.log.Println("Cannot set volume:", err)
+			}
+		} else {
+			p.audio.Volume.SetBypass(response.Dvc == 0)
+			p.audio.Volume.Set(left, right)
+		}
+
+	case *slimproto.Stat:
+		// Request a STAT update from the player
+		p.log.Println("stat:", response.Data)
+
+	case *slimproto.Serv:
+		// Tells the client to switch to another server.
+		ip := response.Server_ip
+		if ip == [4]byte{0, 0, 0, 1} {
+			p.log.Println("Switching to SqueezeNetwork is not supported")
+			break
+		}
+
+		p.log.Printf("Switching to server %v", net.IP(ip[:]))
+		_ = p.slimprotoBye()
+		p.server.mu.Lock()
+		p.server.Addr = net.IPv4(ip[0], ip[1], ip[2], ip[3])
+		p.server.SyncgroupID = string(response.SyncgroupID)
+		p.server.mu.Unlock()
+		errProto = errServerSwitch
+
+	default:
+		// Rest is ignored
+	}
+
+	return
+
+}
+
+// waitJiffies waits for the timestamp of strm u, if non-zero, the
+// player-specific internal timestamp (ms) at which to unpause
+func (p *Player) waitJiffies(timestamp uint32) {
+	// The difference also holds when jiffies wraps around
+	wait := time.Duration(int32(timestamp-jiffies())) * time.Millisecond
+	if timestamp == 0 || wait <= 0 {
+		return
+	}
+	if p.config.Debug {
+		p.log.Printf("Waiting for jiffie %v, now: %v", timestamp, jiffies())
+	}
+	time.Sleep(wait)
+}
+
+// Send STAT message
+func (p *Player) slimprotoSend(timestamp uint32, eventcode string) (err error) {
+	msg := p.slimprotoStat(eventcode)
+	msg.Timestamp = timestamp
+	return p.slimprotoWriteStat(msg)
+}
+
+// Send STMn, the stream cannot be played for the reason in errorCode
+func (p *Player) slimprotoSendError(errorCode uint16) (err error) {
+	msg := p.slimprotoStat("STMn")
+	msg.ErrorCode = errorCode
+	return p.slimprotoWriteStat(msg)
+}
+
+// Fill a STAT message with the state of the buffers and the playback
+func (p *Player) slimprotoStat(eventcode string) *slimproto.STAT {
+
+	var elapsedMillis uint64
+	var elapsedFrames int
+	var err error
+
+	// The output goroutine updates these while the message is filled
+	p.audio.mu.Lock()
+	format := p.audio.Format
+	framesWritten := p.audio.FramesWritten
+	lastFramesWritten := p.audio.LastFramesWritten
+	p.audio.mu.Unlock()
+
+	if framesWritten > 0 && format.Rate > 0 {
+		elapsedFrames, err = p.slimaudioElapsedFrames(framesWritten, lastFramesWritten)
+		if err == nil {
+			elapsedMillis = (uint64(elapsedFrames) * 1000) / uint64(format.Rate)
+		}
+		if p.config.Debug {
+			p.log.Printf("frames written: %v, elapsedFrames: %v, ElapsedMillis: %v",
+				framesWritten, elapsedFrames, elapsedMillis)
+		}
+	}
+
+	// The stream buffer holds the data received but not yet decoded
+	var BufferFullness int
+	var BufferSize int
+	if s := p.buffer.Stream.Load(); p.buffer.Init.Load() && s != nil {
+		BufferFullness = s.in.Buffered()
+		BufferSize = s.in.Size()
+	}
+
+	// The output buffer holds the decoded audio not yet played, in the
+	// buffer of the player and in the buffer of the output, whose size is
+	// in frames
+	var OutputBufferFullness int
+	var OutputBufferSize int
+	if out := p.buffer.Output.Load(); out != nil {
+		OutputBufferFullness = out.Buffered()
+		OutputBufferSize = out.Size()
+	}
+	if format.Rate > 0 {
+		frameSize := format.FrameSize()
+		delayFrames, err := p.audio.Output.Delay()
+		if err == nil && delayFrames > 0 {
+			OutputBufferFullness += delayFrames * frameSize
+		}
+		OutputBufferSize += p.audio.Output.BufferSize() * frameSize
+	}
+
+	if p.config.Debug {
+		p.log.Printf("BufferFullness: %v, BufferSize: %v, OutputBufferFullness: %v, OutputBufferSize: %v",
+			BufferFullness, BufferSize, OutputBufferFullness, OutputBufferSize)
+	}
+
+	msg := slimproto.STAT{
+		BufferSize:           uint32(BufferSize),
+		BufferFullness:       uint32(BufferFullness),
+		BytesReceived:        p.buffer.BytesReceived.Load(),
+		WirelessStrength:     65534,
+		Jiffies:              jiffies(),
+		OutputBufferSize:     uint32(OutputBufferSize),
+		OutputBufferFullness: uint32(OutputBufferFullness),
+		ElapsedSeconds:       uint32(elapsedMillis / 1000),
+		ElapsedMillis:        uint32(elapsedMillis)}
+	copy(msg.EventCode[:], eventcode)
+
+	return &msg
+}
+
+// Write a STAT message to slimproto
+func (p *Player) slimprotoWriteStat(msg *slimproto.STAT) (err error) {
+	err = p.slimprotoWrite(msg)
+	if p.config.Debug {
+		p.log.Printf("[Sent %s]", msg.EventCode[:])
+	}
+	return
+}
+
+// Write a message to slimproto, safe to be called from any goroutine
+func (p *Player) slimprotoWrite(msg slimproto.Message) error {
+	p.server.mu.Lock()
+	defer p.server.mu.Unlock()
+
+	if p.server.Conn == nil {
+		return errors.New("not connected to slimproto")
+	}
+	return slimproto.WriteMessage(p.server.Conn, msg)
+}
+
+// Close slimproto
+func (p *Player) slimprotoClose() {
+	p.server.mu.Lock()
+	defer p.server.mu.Unlock()
+
+	if p.server.Conn == nil {
+		return
+	}
+	err := p.server.Conn.Close()
+	p.checkError(err)
+	if p.config.Debug {
+		p.log.Println("Connection to slimproto closed")
+	}
+}
+
+// Send a HELO message, on a reconnect with the bytes received of the
+// current stream
+func (p *Player) slimprotoHello(reconnect bool, bytesReceived uint64) (err error) {
+
+	p.server.mu.Lock()
+	syncgroupID := p.server.SyncgroupID
+	p.server.mu.Unlock()
+
+	capabilities := slimproto.Capabilities{
+		Model:         "squeezeplay",
+		ModelName:     "SlimGo",
+		Codecs:        decoder.Names(),
+		MaxSampleRate: p.audio.MaxRate,
+		SyncgroupID:   syncgroupID,
+		Flags:         []string{"CanHTTPS=1"},
+	}
+
+	// send a packet
+	msg := slimproto.NewHELO(p.config.MAC, capabilities)
+	msg.UUID = p.config.UUID
+	if reconnect {
+		msg.SetReconnect(bytesReceived)
+	}
+	err = p.slimprotoWrite(msg)
+	if p.config.Debug {
+		p.log.Printf("Sent HELO: %s", msg.Capabilities)
+	}
+
+	// The sync group of a serv is only joined by the first HELO after it,
+	// a reconnect later on must not join it again
+	if err == nil {
+		p.server.mu.Lock()
+		if p.server.SyncgroupID == syncgroupID {
+			p.server.SyncgroupID = ""
+		}
+		p.server.mu.Unlock()
+	}
+
+	return
+}
+
+// Send a BYE! message
+func (p *Player) slimprotoBye() (err error) {
+
+	// send a packet
+	msg := slimproto.BYE{Upgrade: 0}
+	err = p.slimprotoWrite(&msg)
+	if p.config.Debug {
+		p.log.Printf("Sent BYE! msg: %v", msg)
+	}
+
+	return
+}
+
+// Check for errors in err
+func (p *Player) checkError(err error) {
+	if err != nil {
+		p.log.Printf("reader %v\n", err)
+	}
+
+	/*
+		if err != nil {
+			// print error string e.g.
+			// "read tcp example.com:80: resource temporarily unavailable"
+			fmt.Printf("reader %v\n", err)
+
+			// print type of the error, e.g. "*net.OpError"
+			fmt.Printf("%T\n", err)
+
+			if err == os.EINVAL {
+			  // socket is not valid or already closed
+			  fmt.Println("EINVAL");
+			}
+			if err == os.EOF {
+			  // remote peer closed socket
+			  fmt.Println("EOF");
+			}
+
+			// matching rest of the codes needs typecasting, errno is
+			// wrapped on OpError
+			if e, ok := err.(*net.OpError); ok {
+			   // print wrapped error string e.g.
+			   // "os.Errno : resource temporarily unavailable"
+			   fmt.Printf("%T : %v\n", e.Error, e.Error)
+			   if e.Timeout() {
+			     // is this timeout error?
+			     fmt.Println("TIMEOUT")
+			   }
+			   if e.Temporary() {
+			     // is this temporary error?  True on timeout,
+			     // socket interrupts or when buffer is full
+			     fmt.Println("TEMPORARY")
+			   }
+
+			  // specific granular error codes in case we're interested
+			 switch e.Error {
+			    case os.EAGAIN:
+			       // timeout
+			       fmt.Println("EAGAIN")
+			   case os.EPIPE:
+			      // broken pipe (e.g. on connection reset)
+			      fmt.Println("EPIPE")
+			   default:
+			      // just write raw errno code, can be platform specific
+			      // (see syscall for definitions)
+			      fmt.Printf("%d\n", int64(e.Error.(os.Errno)))
+			 }
+			}
+		}
+	*/
+}
@@ -0,0 +1,346 @@
+/*
+ *  (c) 2012 Bart Lauret
+ *
+ *  This file is part of slimgo.
+ *
+ *  slimgo is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  slimgo is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with slimgo.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package player
+
+import (
+	"bytes"
+	"github.com/terual/slimgo/pcm"
+	"github.com/terual/slimgo/slimproto"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testTimeout is how long a test waits for the player
+const testTimeout = 5 * time.Second
+
+// testServer is the server side of the connection of a test player
+type testServer struct {
+	t      *testing.T
+	conn   net.Conn
+	events chan string // of the STAT, RESP and META messages of the player
+	data   chan []byte // of the RESP and META messages
+}
+
+// newTestServer connects p to a server and runs its receive loop
+func newTestServer(t *testing.T, p *Player) *testServer {
+	t.Helper()
+	c1, c2 := net.Pipe()
+	t.Cleanup(func() {
+		c1.Close()
+		c2.Close()
+	})
+	p.server.Conn = c1
+	srv := &testServer{
+		t:      t,
+		conn:   c2,
+		events: make(chan string, 1024),
+		data:   make(chan []byte, 1024),
+	}
+
+	go func() {
+		for p.slimprotoRecv() == nil {
+		}
+	}()
+	go func() {
+		for {
+			msg, err := slimproto.ReadClientMessage(c2)
+			if err != nil {
+				return
+			}
+			switch msg := msg.(type) {
+			case *slimproto.STAT:
+				srv.events <- string(msg.EventCode[:])
+			case *slimproto.RESP:
+				srv.data <- msg.Header
+				srv.events <- msg.Operation()
+			case *slimproto.META:
+				srv.data <- msg.Data
+				srv.events <- msg.Operation()
+			}
+		}
+	}()
+	return srv
+}
+
+// send sends a strm to the player
+func (srv *testServer) send(strm *slimproto.Strm) {
+	srv.t.Helper()
+	if err := slimproto.WriteServerMessage(srv.conn, strm); err != nil {
+		srv.t.Fatal(err)
+	}
+}
+
+// wait waits for the event want and returns the events before it
+func (srv *testServer) wait(want string) (events []string) {
+	srv.t.Helper()
+	timeout := time.After(testTimeout)
+	for {
+		select {
+		case event := <-srv.events:
+			if event == want {
+				return events
+			}
+			events = append(events, event)
+		case <-timeout:
+			srv.t.Fatalf("no %s after %v", want, events)
+		}
+	}
+}
+
+// waitPlayed waits until the output of s is played
+func waitPlayed(t *testing.T, s *stream) {
+	t.Helper()
+	select {
+	case <-s.played:
+	case <-time.After(testTimeout):
+		t.Fatal("stream not played")
+	}
+}
+
+// testFormat is the format of the streams of the tests
+var testFormat = pcm.Format{SampleFormat: pcm.S16LE, Rate: 44100, Channels: 2}
+
+// playTestStream starts a stream of data
+func playTestStream(p *Player, data []byte) *stream {
+	s := newTestStream(p)
+	s.out.setFormat(testFormat)
+	go func() {
+		s.out.Write(data)
+		s.out.closeWrite(io.EOF)
+	}()
+	p.slimbufferPlay(s)
+	return s
+}
+
+func TestPauseUnpause(t *testing.T) {
+	out := new(testOutput)
+	p := newTestPlayer(t, out)
+	srv := newTestServer(t, p)
+
+	srv.send(&slimproto.Strm{Command: 'p'})
+	srv.wait("STMp")
+	if state := p.audio.state(); state != "PAUSED" {
+		t.Fatalf("state %q after strm p", state)
+	}
+
+	data := bytes.Repeat([]byte{1}, 4*1000)
+	s := playTestStream(p, data)
+	time.Sleep(50 * time.Millisecond)
+	if n := len(out.written()); n != 0 {
+		t.Fatalf("wrote %d bytes while paused", n)
+	}
+
+	srv.send(&slimproto.Strm{Command: 'u'})
+	srv.wait("STMr")
+	waitPlayed(t, s)
+	srv.wait("STMu")
+	if got := out.written(); !bytes.Equal(got, data) {
+		t.Errorf("wrote %d bytes, want %d", len(got), len(data))
+	}
+}
+
+func TestTimedPause(t *testing.T) {
+	out := new(testOutput)
+	p := newTestPlayer(t, out)
+	srv := newTestServer(t, p)
+
+	data := bytes.Repeat([]byte{1}, 4*1000)
+	srv.send(&slimproto.Strm{Command: 'p', Replay_gain: 50})
+	s := playTestStream(p, data)
+
+	// The receive loop is not blocked by the pause
+	srv.send(&slimproto.Strm{Command: 't'})
+	srv.wait("STMt")
+
+	// The output resumes by itself, without STMp or STMr
+	waitPlayed(t, s)
+	for _, event := range srv.wait("STMu") {
+		if event == "STMp" || event == "STMr" {
+			t.Errorf("%s sent for a timed pause", event)
+		}
+	}
+	if got := out.written(); !bytes.Equal(got, data) {
+		t.Errorf("wrote %d bytes, want %d", len(got), len(data))
+	}
+	out.mu.Lock()
+	paused := out.paused
+	out.mu.Unlock()
+	if paused {
+		t.Error("output still paused")
+	}
+	if state := p.audio.state(); state != "STOPPED" {
+		t.Errorf("state %q after the stream, want STOPPED", state)
+	}
+}
+
+// TestTimedPauseUnpaused checks that a timed pause which is ended early does
+// not end the pause after it
+func TestTimedPauseUnpaused(t *testing.T) {
+	p := newTestPlayer(t, new(testOutput))
+	srv := newTestServer(t, p)
+
+	srv.send(&slimproto.Strm{Command: 'p', Replay_gain: 20})
+	srv.send(&slimproto.Strm{Command: 'u'})
+	srv.wait("STMr")
+	srv.send(&slimproto.Strm{Command: 'p'})
+	srv.wait("STMp")
+
+	time.Sleep(50 * time.Millisecond)
+	if state := p.audio.state(); state != "PAUSED" {
+		t.Errorf("state %q, want PAUSED", state)
+	}
+}
+
+// TestUnpauseNotStarted checks that strm u starts a stream without
+// autostart which was paused before it started
+func TestUnpauseNotStarted(t *testing.T) {
+	out := new(testOutput)
+	p := newTestPlayer(t, out)
+	srv := newTestServer(t, p)
+
+	srv.send(&slimproto.Strm{Command: 's', Autostart: '0'})
+	srv.wait("STMc")
+	data := bytes.Repeat([]byte{1}, 4*1000)
+	s := newTestStream(p)
+	s.out.setFormat(testFormat)
+	s.out.Write(data)
+	s.out.closeWrite(io.EOF)
+
+	srv.send(&slimproto.Strm{Command: 'p'})
+	srv.wait("STMp")
+	srv.send(&slimproto.Strm{Command: 'u'})
+	srv.wait("STMr")
+	waitPlayed(t, s)
+	if got := out.written(); !bytes.Equal(got, data) {
+		t.Errorf("wrote %d bytes, want %d", len(got), len(data))
+	}
+}
+
+// TestUnpauseAt checks that strm u with a timestamp waits until then
+func TestUnpauseAt(t *testing.T) {
+	p := newTestPlayer(t, new(testOutput))
+	srv := newTestServer(t, p)
+
+	srv.send(&slimproto.Strm{Command: 'p'})
+	srv.wait("STMp")
+	start := time.Now()
+	srv.send(&slimproto.Strm{Command: 'u', Replay_gain: jiffies() + 100})
+	srv.wait("STMr")
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("unpaused after %v, want 100ms", elapsed)
+	}
+	if state := p.audio.state(); state != "PLAYING" {
+		t.Errorf("state %q, want PLAYING", state)
+	}
+}
+
+// TestPauseWhilePlaying pauses and unpauses the output while it plays, no
+// unpause may be lost
+func TestPauseWhilePlaying(t *testing.T) {
+	out := &testOutput{maxFrames: 64}
+	p := newTestPlayer(t, out)
+	srv := newTestServer(t, p)
+
+	data := bytes.Repeat([]byte{1, 2, 3, 4}, 1<<16)
+	s := newTestStream(p)
+	s.out.setFormat(testFormat)
+	go func() {
+		for i := 0; i < len(data); i += 4096 {
+			s.out.Write(data[i : i+4096])
+			time.Sleep(time.Millisecond)
+		}
+		s.out.closeWrite(io.EOF)
+	}()
+	p.slimbufferPlay(s)
+
+	for i := 0; i < 20; i++ {
+		srv.send(&slimproto.Strm{Command: 'p'})
+		srv.wait("STMp")
+		srv.send(&slimproto.Strm{Command: 'u'})
+		srv.wait("STMr")
+	}
+	waitPlayed(t, s)
+	if got := out.written(); !bytes.Equal(got, data) {
+		t.Errorf("wrote %d bytes, want %d", len(got), len(data))
+	}
+}
+
+// TestServ checks that a serv switches to the server and sync group in it
+func TestServ(t *testing.T) {
+	p := newTestPlayer(t, new(testOutput))
+	srv := newTestServer(t, p)
+
+	serv := &slimproto.Serv{Server_ip: [4]byte{10, 0, 0, 2}, SyncgroupID: []byte("0123456789")}
+	if err := slimproto.WriteServerMessage(srv.conn, serv); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		p.server.mu.Lock()
+		defer p.server.mu.Unlock()
+		return p.server.Addr.Equal(net.IPv4(10, 0, 0, 2))
+	})
+	p.server.mu.Lock()
+	syncgroupID := p.server.SyncgroupID
+	p.server.mu.Unlock()
+	if syncgroupID != "0123456789" {
+		t.Errorf("SyncgroupID %q, want %q", syncgroupID, "0123456789")
+	}
+}
+
+func TestHelloSyncgroupID(t *testing.T) {
+	l, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	conn, err := net.DialTCP("tcp", nil, l.Addr().(*net.TCPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	srv, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	p := &Player{log: log.New(io.Discard, "", 0)}
+	p.server.Conn = conn
+	p.server.SyncgroupID = "0123456789"
+
+	// Only the first HELO after the serv joins the sync group
+	for i, want := range []bool{true, false} {
+		if err := p.slimprotoHello(i > 0, 0); err != nil {
+			t.Fatal(err)
+		}
+		msg, err := slimproto.ReadClientMessage(srv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		joined := strings.Contains(msg.(*slimproto.HELO).Capabilities, "SyncgroupID=0123456789")
+		if joined != want {
+			t.Errorf("HELO %d: SyncgroupID sent: %v, want %v", i, joined, want)
+		}
+	}
+}
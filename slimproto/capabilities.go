@@ -0,0 +1,101 @@
+/*
+ *  (c) 2012 Bart Lauret
+ *
+ *  This file is part of slimgo.
+ *
+ *  slimgo is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  slimgo is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with slimgo.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package slimproto
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Device IDs sent in HELO
+const (
+	DeviceSqueezebox2 = 4
+	DeviceTransporter = 5
+	DeviceReceiver    = 7
+	DeviceController  = 9
+	DeviceBoom        = 10
+	DeviceSqueezePlay = 12
+)
+
+// WLanReconnect is set in the WLanChannelList of HELO when the player
+// reconnects to the server it was connected to before.
+const WLanReconnect = 0x4000
+
+// Capabilities is the set of capabilities a player sends in HELO.
+type Capabilities struct {
+	Model              string   // e.g. squeezeplay, selects the player type in the server
+	ModelName          string   // shown in the server
+	Firmware           string   // shown in the server
+	Codecs             []string // the formats the player can decode, e.g. pcm, flc, mp3
+	MaxSampleRate      int      // highest sample rate of the output
+	AccuratePlayPoints bool     // the elapsed time is accurate enough for synchronisation
+	HasDigitalOut      bool
+	SyncgroupID        string   // the sync group to join after a serv
+	Flags              []string // any other capability, e.g. CanHTTPS=1
+}
+
+// String returns the capability string of c, a comma separated list of
+// key=value pairs and codecs.
+func (c Capabilities) String() string {
+	var caps []string
+
+	if c.Model != "" {
+		caps = append(caps, "model="+c.Model)
+	}
+	if c.ModelName != "" {
+		caps = append(caps, "modelName="+c.ModelName)
+	}
+	if c.Firmware != "" {
+		caps = append(caps, "Firmware="+c.Firmware)
+	}
+	caps = append(caps, c.Codecs...)
+	if c.MaxSampleRate > 0 {
+		caps = append(caps, "MaxSampleRate="+strconv.Itoa(c.MaxSampleRate))
+	}
+	if c.AccuratePlayPoints {
+		caps = append(caps, "AccuratePlayPoints=1")
+	}
+	if c.HasDigitalOut {
+		caps = append(caps, "HasDigitalOut=1")
+	}
+	if c.SyncgroupID != "" {
+		caps = append(caps, "SyncgroupID="+c.SyncgroupID)
+	}
+	caps = append(caps, c.Flags...)
+
+	return strings.Join(caps, ",")
+}
+
+// NewHELO returns a HELO for a SqueezePlay player with MAC address mac.
+func NewHELO(mac [6]uint8, caps Capabilities) *HELO {
+	return &HELO{
+		DeviceID:     DeviceSqueezePlay,
+		Revision:     255,
+		MAC:          mac,
+		Capabilities: caps.String(),
+	}
+}
+
+// SetReconnect marks m as a reconnect to the same server. The server then
+// resumes the current stream instead of restarting it, provided that
+// bytesReceived matches what it has sent.
+func (m *HELO) SetReconnect(bytesReceived uint64) {
+	m.WLanChannelList |= WLanReconnect
+	m.BytesReceived = bytesReceived
+}
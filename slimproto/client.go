@@ -0,0 +1,264 @@
+/*
+ *  (c) 2012 Bart Lauret
+ *
+ *  This file is part of slimgo.
+ *
+ *  slimgo is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  slimgo is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with slimgo.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package slimproto
+
+import (
+	"encoding/binary"
+)
+
+// Frames sent by the player to the server
+
+// HELO, the first frame sent after connecting. Capabilities is a comma
+// separated list of codecs and key=value pairs and can be of any length.
+type HELO struct {
+	DeviceID        uint8
+	Revision        uint8
+	MAC             [6]uint8
+	UUID            [16]uint8
+	WLanChannelList uint16
+	BytesReceived   uint64
+	Language        [2]uint8
+	Capabilities    string
+}
+
+// heloLen is the size of HELO without the capabilities
+const heloLen = 36
+
+func (m *HELO) Operation() string { return "HELO" }
+
+func (m *HELO) MarshalBinary() ([]byte, error) {
+	b := make([]byte, heloLen, heloLen+len(m.Capabilities))
+	b[0] = m.DeviceID
+	b[1] = m.Revision
+	copy(b[2:8], m.MAC[:])
+	copy(b[8:24], m.UUID[:])
+	binary.BigEndian.PutUint16(b[24:26], m.WLanChannelList)
+	binary.BigEndian.PutUint64(b[26:34], m.BytesReceived)
+	copy(b[34:36], m.Language[:])
+	return append(b, m.Capabilities...), nil
+}
+
+func (m *HELO) UnmarshalBinary(data []byte) error {
+	if len(data) < heloLen {
+		return ErrShortMessage
+	}
+	m.DeviceID = data[0]
+	m.Revision = data[1]
+	copy(m.MAC[:], data[2:8])
+	copy(m.UUID[:], data[8:24])
+	m.WLanChannelList = binary.BigEndian.Uint16(data[24:26])
+	m.BytesReceived = binary.BigEndian.Uint64(data[26:34])
+	copy(m.Language[:], data[34:36])
+	m.Capabilities = string(data[heloLen:])
+	return nil
+}
+
+/*
+u32 	Event Code (a 4 byte string)
+u8 		Number of consecutive CRLF recieved while parsing headers
+u8 		MAS Initalized - 'm' or 'p'
+u8 		MAS Mode - serdes mode?
+u32 	buffer size - in bytes, of the player's (network/stream) buffer
+u32 	fullness - data bytes in the player's (network/stream) buffer
+u64 	Bytes Recieved
+u16 	Wireless Signal Strength (0-100 - Larger values mean hardwired)
+u32 	jiffies - a timestamp from the player (@1kHz)
+u32 	output buffer size - the decoded audio data buffer size
+u32 	output buffer fullness - bytes in the decoded audio data buffer
+u32 	elapsed seconds - of the current stream
+u16 	voltage
+u32 	elapsed milliseconds - of the current stream
+u32 	server timestamp - reflected from an strm-t command
+u16 	error code - used with STMn */
+
+// STAT, reports an event and the status of the player.
+type STAT struct {
+	EventCode            [4]byte
+	CRLF                 uint8
+	MASInit              uint8
+	MASMode              uint8
+	BufferSize           uint32
+	BufferFullness       uint32
+	BytesReceived        uint64
+	WirelessStrength     uint16
+	Jiffies              uint32
+	OutputBufferSize     uint32
+	OutputBufferFullness uint32
+	ElapsedSeconds       uint32
+	Voltage              uint16
+	ElapsedMillis        uint32
+	Timestamp            uint32
+	ErrorCode            uint16
+}
+
+// statLen is the size of STAT
+const statLen = 53
+
+func (m *STAT) Operation() string { return "STAT" }
+
+func (m *STAT) MarshalBinary() ([]byte, error) {
+	b := make([]byte, statLen)
+	copy(b[0:4], m.EventCode[:])
+	b[4] = m.CRLF
+	b[5] = m.MASInit
+	b[6] = m.MASMode
+	binary.BigEndian.PutUint32(b[7:11], m.BufferSize)
+	binary.BigEndian.PutUint32(b[11:15], m.BufferFullness)
+	binary.BigEndian.PutUint64(b[15:23], m.BytesReceived)
+	binary.BigEndian.PutUint16(b[23:25], m.WirelessStrength)
+	binary.BigEndian.PutUint32(b[25:29], m.Jiffies)
+	binary.BigEndian.PutUint32(b[29:33], m.OutputBufferSize)
+	binary.BigEndian.PutUint32(b[33:37], m.OutputBufferFullness)
+	binary.BigEndian.PutUint32(b[37:41], m.ElapsedSeconds)
+	binary.BigEndian.PutUint16(b[41:43], m.Voltage)
+	binary.BigEndian.PutUint32(b[43:47], m.ElapsedMillis)
+	binary.BigEndian.PutUint32(b[47:51], m.Timestamp)
+	binary.BigEndian.PutUint16(b[51:53], m.ErrorCode)
+	return b, nil
+}
+
+func (m *STAT) UnmarshalBinary(data []byte) error {
+	if len(data) < statLen {
+		return ErrShortMessage
+	}
+	copy(m.EventCode[:], data[0:4])
+	m.CRLF = data[4]
+	m.MASInit = data[5]
+	m.MASMode = data[6]
+	m.BufferSize = binary.BigEndian.Uint32(data[7:11])
+	m.BufferFullness = binary.BigEndian.Uint32(data[11:15])
+	m.BytesReceived = binary.BigEndian.Uint64(data[15:23])
+	m.WirelessStrength = binary.BigEndian.Uint16(data[23:25])
+	m.Jiffies = binary.BigEndian.Uint32(data[25:29])
+	m.OutputBufferSize = binary.BigEndian.Uint32(data[29:33])
+	m.OutputBufferFullness = binary.BigEndian.Uint32(data[33:37])
+	m.ElapsedSeconds = binary.BigEndian.Uint32(data[37:41])
+	m.Voltage = binary.BigEndian.Uint16(data[41:43])
+	m.ElapsedMillis = binary.BigEndian.Uint32(data[43:47])
+	m.Timestamp = binary.BigEndian.Uint32(data[47:51])
+	m.ErrorCode = binary.BigEndian.Uint16(data[51:53])
+	return nil
+}
+
+// BYE!, sent before the player disconnects.
+type BYE struct {
+	Upgrade uint8
+}
+
+func (m *BYE) Operation() string { return "BYE!" }
+
+func (m *BYE) MarshalBinary() ([]byte, error) {
+	return []byte{m.Upgrade}, nil
+}
+
+func (m *BYE) UnmarshalBinary(data []byte) error {
+	if len(data) < 1 {
+		return ErrShortMessage
+	}
+	m.Upgrade = data[0]
+	return nil
+}
+
+// RESP, the HTTP response headers of the stream.
+type RESP struct {
+	Header []byte
+}
+
+func (m *RESP) Operation() string { return "RESP" }
+
+func (m *RESP) MarshalBinary() ([]byte, error) {
+	return append([]byte(nil), m.Header...), nil
+}
+
+func (m *RESP) UnmarshalBinary(data []byte) error {
+	m.Header = append([]byte(nil), data...)
+	return nil
+}
+
+// META, the shoutcast metadata of the stream.
+type META struct {
+	Data []byte
+}
+
+func (m *META) Operation() string { return "META" }
+
+func (m *META) MarshalBinary() ([]byte, error) {
+	return append([]byte(nil), m.Data...), nil
+}
+
+func (m *META) UnmarshalBinary(data []byte) error {
+	m.Data = append([]byte(nil), data...)
+	return nil
+}
+
+// Error codes sent with STMn
+const (
+	ErrorUnsupportedFormat = 1 // the player cannot decode the format
+	ErrorOutput            = 2 // the output does not support the format
+	ErrorStream            = 3 // the stream could not be opened
+)
+
+// Reasons for DSCO
+const (
+	DscoClosed      = 0 // connection closed normally
+	DscoReset       = 1 // connection reset by local host
+	DscoResetRemote = 2 // connection reset by remote host
+	DscoUnreachable = 3 // unable to connect
+	DscoTimeout     = 4 // connection timed out
+)
+
+// DSCO, sent when the stream connection is closed.
+type DSCO struct {
+	Reason uint8
+}
+
+func (m *DSCO) Operation() string { return "DSCO" }
+
+func (m *DSCO) MarshalBinary() ([]byte, error) {
+	return []byte{m.Reason}, nil
+}
+
+func (m *DSCO) UnmarshalBinary(data []byte) error {
+	if len(data) < 1 {
+		return ErrShortMessage
+	}
+	m.Reason = data[0]
+	return nil
+}
+
+// SETD, the answer to a setd request.
+type SETD struct {
+	ID   uint8
+	Data []byte
+}
+
+func (m *SETD) Operation() string { return "SETD" }
+
+func (m *SETD) MarshalBinary() ([]byte, error) {
+	return append([]byte{m.ID}, m.Data...), nil
+}
+
+func (m *SETD) UnmarshalBinary(data []byte) error {
+	if len(data) < 1 {
+		return ErrShortMessage
+	}
+	m.ID = data[0]
+	m.Data = append([]byte(nil), data[1:]...)
+	return nil
+}
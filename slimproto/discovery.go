@@ -0,0 +1,163 @@
+/*
+ *  (c) 2012 Bart Lauret
+ *
+ *  This file is part of slimgo.
+ *
+ *  slimgo is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  slimgo is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with slimgo.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package slimproto
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// discoveryRequest asks for the address, name, JSON port, version and UUID
+// of the server. Every tag is followed by a zero length.
+const discoveryRequest = "eIPAD\x00NAME\x00JSON\x00VERS\x00UUID\x00"
+
+// Server is a Logitech Media Server found by discovery.
+type Server struct {
+	Addr     net.IP // address of the server
+	Port     int    // slimproto port
+	Name     string
+	JSONPort int // port of the web interface and JSON-RPC
+	Version  string
+	UUID     string
+}
+
+func (s Server) String() string {
+	return fmt.Sprintf("%s (%v:%v, version %s, uuid %s)", s.Name, s.Addr, s.Port, s.Version, s.UUID)
+}
+
+// Match reports whether s is the server given by spec, which is either
+// name:<name>, uuid:<uuid> or an IP-address.
+func (s Server) Match(spec string) bool {
+	switch {
+	case strings.HasPrefix(spec, "name:"):
+		return strings.EqualFold(s.Name, spec[len("name:"):])
+	case strings.HasPrefix(spec, "uuid:"):
+		return strings.EqualFold(s.UUID, spec[len("uuid:"):])
+	}
+	ip := net.ParseIP(spec)
+	return ip != nil && ip.Equal(s.Addr)
+}
+
+// Discover broadcasts a discovery request on the local network and returns
+// every server that answers within timeout.
+func Discover(timeout time.Duration) ([]Server, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	_, err = conn.WriteToUDP([]byte(discoveryRequest), &net.UDPAddr{
+		IP:   net.IPv4bcast,
+		Port: Port,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = conn.SetReadDeadline(time.Now().Add(timeout))
+	if err != nil {
+		return nil, err
+	}
+
+	var servers []Server
+	seen := make(map[string]bool)
+	data := make([]byte, 1500)
+	for {
+		n, remoteAddr, err := conn.ReadFromUDP(data)
+		if err != nil {
+			if e, ok := err.(net.Error); ok && e.Timeout() {
+				break
+			}
+			return servers, err
+		}
+
+		server, err := ParseDiscoveryResponse(data[:n], remoteAddr.IP)
+		if err != nil {
+			// Not an answer to our request, e.g. a request of another player
+			continue
+		}
+		if seen[server.Addr.String()] {
+			continue
+		}
+		seen[server.Addr.String()] = true
+		servers = append(servers, server)
+	}
+
+	return servers, nil
+}
+
+// ParseDiscoveryResponse parses the answer of a server to a discovery
+// request. The answer starts with an 'E' followed by tags of 4 bytes, a
+// length byte and the value. from is used if the server did not send its
+// address.
+func ParseDiscoveryResponse(data []byte, from net.IP) (server Server, err error) {
+	if len(data) < 1 || data[0] != 'E' {
+		return server, errors.New("slimproto: not a discovery response")
+	}
+
+	server.Addr = from
+	server.Port = Port
+	data = data[1:]
+	for len(data) > 0 {
+		if len(data) < 5 || len(data) < 5+int(data[4]) {
+			return server, errors.New("slimproto: truncated discovery response")
+		}
+		tag, value := string(data[0:4]), string(data[5:5+int(data[4])])
+		data = data[5+int(data[4]):]
+
+		switch tag {
+		case "IPAD":
+			if ip := net.ParseIP(value); ip != nil {
+				server.Addr = ip
+			}
+		case "NAME":
+			server.Name = value
+		case "JSON":
+			server.JSONPort, _ = strconv.Atoi(value)
+		case "VERS":
+			server.Version = value
+		case "UUID":
+			server.UUID = value
+		}
+	}
+
+	if server.Addr == nil {
+		return server, errors.New("slimproto: discovery response without address")
+	}
+	return server, nil
+}
+
+// SelectServer returns the first of servers matching spec, or the first
+// server if spec is empty.
+func SelectServer(servers []Server, spec string) (Server, error) {
+	for _, server := range servers {
+		if spec == "" || server.Match(spec) {
+			return server, nil
+		}
+	}
+	if spec == "" {
+		return Server{}, errors.New("slimproto: no servers found")
+	}
+	return Server{}, fmt.Errorf("slimproto: no server found for %s", spec)
+}
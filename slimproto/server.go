@@ -0,0 +1,279 @@
+/*
+ *  (c) 2012 Bart Lauret
+ *
+ *  This file is part of slimgo.
+ *
+ *  slimgo is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  slimgo is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with slimgo.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package slimproto
+
+import (
+	"encoding/binary"
+)
+
+// Frames sent by the server to the player
+
+// strm, sent to control the stream and the playback. If the command
+// starts a stream, HTTPHeader holds the request the player has to send.
+type Strm struct {
+	Command          uint8
+	Autostart        uint8
+	Formatbyte       uint8
+	Pcmsamplesize    uint8
+	Pcmsamplerate    uint8
+	Pcmchannels      uint8
+	Pcmendian        uint8
+	Threshold        uint8
+	Spdif_enable     uint8
+	Trans_period     uint8
+	Trans_type       uint8
+	Flags            uint8
+	Output_threshold uint8
+	RESERVED         uint8
+	Replay_gain      uint32
+	Server_port      uint16
+	Server_ip        [4]byte
+	HTTPHeader       []byte
+}
+
+// StrmFlagTLS is set in the Flags of strm when the stream is HTTPS, only
+// sent to players with CanHTTPS.
+const StrmFlagTLS = 0x20
+
+// strmLen is the size of the fixed part of strm
+const strmLen = 24
+
+func (m *Strm) Operation() string { return "strm" }
+
+func (m *Strm) MarshalBinary() ([]byte, error) {
+	b := make([]byte, strmLen, strmLen+len(m.HTTPHeader))
+	b[0] = m.Command
+	b[1] = m.Autostart
+	b[2] = m.Formatbyte
+	b[3] = m.Pcmsamplesize
+	b[4] = m.Pcmsamplerate
+	b[5] = m.Pcmchannels
+	b[6] = m.Pcmendian
+	b[7] = m.Threshold
+	b[8] = m.Spdif_enable
+	b[9] = m.Trans_period
+	b[10] = m.Trans_type
+	b[11] = m.Flags
+	b[12] = m.Output_threshold
+	b[13] = m.RESERVED
+	binary.BigEndian.PutUint32(b[14:18], m.Replay_gain)
+	binary.BigEndian.PutUint16(b[18:20], m.Server_port)
+	copy(b[20:24], m.Server_ip[:])
+	return append(b, m.HTTPHeader...), nil
+}
+
+func (m *Strm) UnmarshalBinary(data []byte) error {
+	if len(data) < strmLen {
+		return ErrShortMessage
+	}
+	m.Command = data[0]
+	m.Autostart = data[1]
+	m.Formatbyte = data[2]
+	m.Pcmsamplesize = data[3]
+	m.Pcmsamplerate = data[4]
+	m.Pcmchannels = data[5]
+	m.Pcmendian = data[6]
+	m.Threshold = data[7]
+	m.Spdif_enable = data[8]
+	m.Trans_period = data[9]
+	m.Trans_type = data[10]
+	m.Flags = data[11]
+	m.Output_threshold = data[12]
+	m.RESERVED = data[13]
+	m.Replay_gain = binary.BigEndian.Uint32(data[14:18])
+	m.Server_port = binary.BigEndian.Uint16(data[18:20])
+	copy(m.Server_ip[:], data[20:24])
+	m.HTTPHeader = nil
+	if len(data) > strmLen {
+		m.HTTPHeader = append([]byte(nil), data[strmLen:]...)
+	}
+	return nil
+}
+
+// cont, sent after a stream has started with the interval of the
+// shoutcast metadata and whether the stream loops.
+type Cont struct {
+	Metaint uint32
+	Loop    uint8
+	Data    []byte
+}
+
+func (m *Cont) Operation() string { return "cont" }
+
+func (m *Cont) MarshalBinary() ([]byte, error) {
+	b := make([]byte, 5, 5+len(m.Data))
+	binary.BigEndian.PutUint32(b[0:4], m.Metaint)
+	b[4] = m.Loop
+	return append(b, m.Data...), nil
+}
+
+func (m *Cont) UnmarshalBinary(data []byte) error {
+	if len(data) < 5 {
+		return ErrShortMessage
+	}
+	m.Metaint = binary.BigEndian.Uint32(data[0:4])
+	m.Loop = data[4]
+	m.Data = append([]byte(nil), data[5:]...)
+	return nil
+}
+
+// audg, sets the gain of the audio output. The old values are in the
+// range 0-128, the new values are 16.16 fixed point (0-65536 is unity).
+type Audg struct {
+	Old_left       uint32 // 0-128
+	Old_right      uint32 // 0-128
+	Dvc            uint8
+	Preamp         uint8
+	New_left       uint32 // 0-65536
+	New_right      uint32 // 0-65536
+	SequenceNumber uint32
+}
+
+// audgLen is the size of audg without the optional sequence number
+const audgLen = 18
+
+func (m *Audg) Operation() string { return "audg" }
+
+func (m *Audg) MarshalBinary() ([]byte, error) {
+	b := make([]byte, audgLen+4)
+	binary.BigEndian.PutUint32(b[0:4], m.Old_left)
+	binary.BigEndian.PutUint32(b[4:8], m.Old_right)
+	b[8] = m.Dvc
+	b[9] = m.Preamp
+	binary.BigEndian.PutUint32(b[10:14], m.New_left)
+	binary.BigEndian.PutUint32(b[14:18], m.New_right)
+	binary.BigEndian.PutUint32(b[18:22], m.SequenceNumber)
+	return b, nil
+}
+
+func (m *Audg) UnmarshalBinary(data []byte) error {
+	if len(data) < audgLen {
+		return ErrShortMessage
+	}
+	m.Old_left = binary.BigEndian.Uint32(data[0:4])
+	m.Old_right = binary.BigEndian.Uint32(data[4:8])
+	m.Dvc = data[8]
+	m.Preamp = data[9]
+	m.New_left = binary.BigEndian.Uint32(data[10:14])
+	m.New_right = binary.BigEndian.Uint32(data[14:18])
+	m.SequenceNumber = 0
+	if len(data) >= audgLen+4 {
+		m.SequenceNumber = binary.BigEndian.Uint32(data[18:22])
+	}
+	return nil
+}
+
+// aude, enables or disables the S/PDIF and DAC outputs.
+type Aude struct {
+	Spdif_enable uint8
+	Dac_enable   uint8
+}
+
+func (m *Aude) Operation() string { return "aude" }
+
+func (m *Aude) MarshalBinary() ([]byte, error) {
+	return []byte{m.Spdif_enable, m.Dac_enable}, nil
+}
+
+func (m *Aude) UnmarshalBinary(data []byte) error {
+	if len(data) < 2 {
+		return ErrShortMessage
+	}
+	m.Spdif_enable = data[0]
+	m.Dac_enable = data[1]
+	return nil
+}
+
+// setd, requests (empty Data) or sets a player setting identified by ID.
+type Setd struct {
+	ID   uint8
+	Data []byte
+}
+
+func (m *Setd) Operation() string { return "setd" }
+
+func (m *Setd) MarshalBinary() ([]byte, error) {
+	return append([]byte{m.ID}, m.Data...), nil
+}
+
+func (m *Setd) UnmarshalBinary(data []byte) error {
+	if len(data) < 1 {
+		return ErrShortMessage
+	}
+	m.ID = data[0]
+	m.Data = append([]byte(nil), data[1:]...)
+	return nil
+}
+
+// serv, tells the player to switch to another server. A server IP of
+// 0.0.0.1 means SqueezeNetwork.
+type Serv struct {
+	Server_ip   [4]byte
+	SyncgroupID []byte // optional, 10 bytes
+}
+
+func (m *Serv) Operation() string { return "serv" }
+
+func (m *Serv) MarshalBinary() ([]byte, error) {
+	return append(m.Server_ip[:4:4], m.SyncgroupID...), nil
+}
+
+func (m *Serv) UnmarshalBinary(data []byte) error {
+	if len(data) < 4 {
+		return ErrShortMessage
+	}
+	copy(m.Server_ip[:], data[0:4])
+	m.SyncgroupID = nil
+	if len(data) > 4 {
+		m.SyncgroupID = append([]byte(nil), data[4:]...)
+	}
+	return nil
+}
+
+// stat, requests a STAT update from the player.
+type Stat struct {
+	Data []byte
+}
+
+func (m *Stat) Operation() string { return "stat" }
+
+func (m *Stat) MarshalBinary() ([]byte, error) {
+	return append([]byte(nil), m.Data...), nil
+}
+
+func (m *Stat) UnmarshalBinary(data []byte) error {
+	m.Data = append([]byte(nil), data...)
+	return nil
+}
+
+// vers, the version of the server.
+type Vers struct {
+	Version string
+}
+
+func (m *Vers) Operation() string { return "vers" }
+
+func (m *Vers) MarshalBinary() ([]byte, error) {
+	return []byte(m.Version), nil
+}
+
+func (m *Vers) UnmarshalBinary(data []byte) error {
+	m.Version = string(data)
+	return nil
+}
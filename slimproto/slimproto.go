@@ -0,0 +1,185 @@
+/*
+ *  (c) 2012 Bart Lauret
+ *
+ *  This file is part of slimgo.
+ *
+ *  slimgo is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  slimgo is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with slimgo.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package slimproto implements the frames of the SlimProto protocol spoken
+// between a Logitech Media Server and a Squeezebox player.
+//
+// Frames sent by the server have a lowercase command (strm, audg, ...) and
+// are prefixed by a 2-byte length which includes the command. Frames sent by
+// the player have an uppercase command (HELO, STAT, ...) followed by a 4-byte
+// length of the body only. Every frame is a Go type implementing Message.
+package slimproto
+
+import (
+	"encoding"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Port is the default TCP (slimproto) and UDP (discovery) port of the server.
+const Port = 3483
+
+// ErrShortMessage is returned when a frame body is smaller than its fixed part.
+var ErrShortMessage = errors.New("slimproto: message too short")
+
+// Message is a single slimproto frame. MarshalBinary and UnmarshalBinary
+// handle the body only, the header is handled by ReadMessage and WriteMessage.
+type Message interface {
+	Operation() string
+	encoding.BinaryMarshaler
+	encoding.BinaryUnmarshaler
+}
+
+// Unknown holds the body of a frame this package has no type for.
+type Unknown struct {
+	Op   string
+	Data []byte
+}
+
+func (m *Unknown) Operation() string { return m.Op }
+
+func (m *Unknown) MarshalBinary() ([]byte, error) {
+	return append([]byte(nil), m.Data...), nil
+}
+
+func (m *Unknown) UnmarshalBinary(data []byte) error {
+	m.Data = append(m.Data[:0], data...)
+	return nil
+}
+
+// newServerMessage returns an empty message for a server command
+func newServerMessage(cmd string) Message {
+	switch cmd {
+	case "strm":
+		return new(Strm)
+	case "cont":
+		return new(Cont)
+	case "audg":
+		return new(Audg)
+	case "aude":
+		return new(Aude)
+	case "setd":
+		return new(Setd)
+	case "serv":
+		return new(Serv)
+	case "stat":
+		return new(Stat)
+	case "vers":
+		return new(Vers)
+	}
+	return &Unknown{Op: cmd}
+}
+
+// newClientMessage returns an empty message for a player command
+func newClientMessage(cmd string) Message {
+	switch cmd {
+	case "HELO":
+		return new(HELO)
+	case "STAT":
+		return new(STAT)
+	case "BYE!":
+		return new(BYE)
+	case "RESP":
+		return new(RESP)
+	case "META":
+		return new(META)
+	case "DSCO":
+		return new(DSCO)
+	case "SETD":
+		return new(SETD)
+	}
+	return &Unknown{Op: cmd}
+}
+
+// ReadMessage reads a single frame sent by the server.
+func ReadMessage(r io.Reader) (Message, error) {
+	var hdr [6]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	length := int(binary.BigEndian.Uint16(hdr[0:2]))
+	if length < 4 {
+		return nil, fmt.Errorf("slimproto: invalid frame length %v", length)
+	}
+
+	body := make([]byte, length-4)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+
+	m := newServerMessage(string(hdr[2:6]))
+	if err := m.UnmarshalBinary(body); err != nil {
+		return nil, fmt.Errorf("slimproto: %s: %v", m.Operation(), err)
+	}
+	return m, nil
+}
+
+// WriteServerMessage writes a single frame sent by the server, which is
+// mostly useful to fake a server.
+func WriteServerMessage(w io.Writer, m Message) error {
+	body, err := m.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	if len(body)+4 > 0xffff {
+		return fmt.Errorf("slimproto: %s: frame too large", m.Operation())
+	}
+
+	frame := make([]byte, 6, 6+len(body))
+	binary.BigEndian.PutUint16(frame[0:2], uint16(len(body)+4))
+	copy(frame[2:6], m.Operation())
+	_, err = w.Write(append(frame, body...))
+	return err
+}
+
+// WriteMessage writes a single frame sent by the player. The frame is
+// written with one call to w.Write.
+func WriteMessage(w io.Writer, m Message) error {
+	body, err := m.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	frame := make([]byte, 8, 8+len(body))
+	copy(frame[0:4], m.Operation())
+	binary.BigEndian.PutUint32(frame[4:8], uint32(len(body)))
+	_, err = w.Write(append(frame, body...))
+	return err
+}
+
+// ReadClientMessage reads a single frame sent by the player.
+func ReadClientMessage(r io.Reader) (Message, error) {
+	var hdr [8]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+
+	body := make([]byte, binary.BigEndian.Uint32(hdr[4:8]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+
+	m := newClientMessage(string(hdr[0:4]))
+	if err := m.UnmarshalBinary(body); err != nil {
+		return nil, fmt.Errorf("slimproto: %s: %v", m.Operation(), err)
+	}
+	return m, nil
+}
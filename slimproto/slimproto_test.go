@@ -0,0 +1,318 @@
+/*
+ *  (c) 2012 Bart Lauret
+ *
+ *  This file is part of slimgo.
+ *
+ *  slimgo is free software: you can redistribute it and/or modify
+ *  it under the terms of the GNU General Public License as published by
+ *  the Free Software Foundation, either version 3 of the License, or
+ *  (at your option) any later version.
+ *
+ *  slimgo is distributed in the hope that it will be useful,
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *  GNU General Public License for more details.
+ *
+ *  You should have received a copy of the GNU General Public License
+ *  along with slimgo.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package slimproto
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestClientMessageRoundTrip(t *testing.T) {
+	messages := []Message{
+		&HELO{
+			DeviceID:        DeviceSqueezePlay,
+			Revision:        255,
+			MAC:             [6]uint8{0, 1, 2, 3, 4, 5},
+			UUID:            [16]uint8{15: 1},
+			WLanChannelList: WLanReconnect,
+			BytesReceived:   1 << 40,
+			Language:        [2]uint8{'e', 'n'},
+			Capabilities:    "model=squeezeplay,pcm,flc",
+		},
+		&STAT{
+			EventCode:            [4]byte{'S', 'T', 'M', 't'},
+			CRLF:                 1,
+			MASInit:              'm',
+			MASMode:              2,
+			BufferSize:           2 << 20,
+			BufferFullness:       1 << 20,
+			BytesReceived:        1 << 33,
+			WirelessStrength:     101,
+			Jiffies:              0xdeadbeef,
+			OutputBufferSize:     4 << 20,
+			OutputBufferFullness: 3 << 20,
+			ElapsedSeconds:       61,
+			Voltage:              5,
+			ElapsedMillis:        61001,
+			Timestamp:            0xcafe,
+			ErrorCode:            ErrorStream,
+		},
+		&BYE{Upgrade: 1},
+		&RESP{Header: []byte("HTTP/1.0 200 OK\r\n\r\n")},
+		&META{Data: []byte("StreamTitle='x';")},
+		&DSCO{Reason: DscoTimeout},
+		&SETD{ID: 0, Data: []byte("name\x00")},
+		&Unknown{Op: "ANIC", Data: []byte{1}},
+	}
+	for _, m := range messages {
+		var b bytes.Buffer
+		if err := WriteMessage(&b, m); err != nil {
+			t.Fatalf("%s: %v", m.Operation(), err)
+		}
+		got, err := ReadClientMessage(&b)
+		if err != nil {
+			t.Fatalf("%s: %v", m.Operation(), err)
+		}
+		if !reflect.DeepEqual(got, m) {
+			t.Errorf("%s: read %+v, want %+v", m.Operation(), got, m)
+		}
+		if b.Len() != 0 {
+			t.Errorf("%s: %d bytes left", m.Operation(), b.Len())
+		}
+	}
+}
+
+func TestServerMessageRoundTrip(t *testing.T) {
+	messages := []Message{
+		&Strm{
+			Command:          's',
+			Autostart:        '1',
+			Formatbyte:       'p',
+			Pcmsamplesize:    '1',
+			Pcmsamplerate:    '3',
+			Pcmchannels:      '2',
+			Pcmendian:        '1',
+			Threshold:        255,
+			Spdif_enable:     '0',
+			Trans_period:     10,
+			Trans_type:       '1',
+			Flags:            StrmFlagTLS,
+			Output_threshold: 1,
+			Replay_gain:      0x10000,
+			Server_port:      9000,
+			Server_ip:        [4]byte{192, 168, 1, 10},
+			HTTPHeader:       []byte("GET /stream.mp3?player=x HTTP/1.0\r\n\r\n"),
+		},
+		&Strm{Command: 'q'},
+		&Cont{Metaint: 8192, Loop: 1, Data: []byte{1, 2}},
+		&Audg{Old_left: 128, Old_right: 64, Dvc: 1, Preamp: 255, New_left: 65536, New_right: 32768, SequenceNumber: 7},
+		&Aude{Spdif_enable: 1, Dac_enable: 1},
+		&Setd{ID: 4, Data: []byte("name")},
+		&Setd{ID: 0},
+		&Serv{Server_ip: [4]byte{10, 0, 0, 1}, SyncgroupID: []byte("0123456789")},
+		&Serv{Server_ip: [4]byte{0, 0, 0, 1}},
+		&Stat{Data: []byte{0, 0, 0, 1}},
+		&Vers{Version: "8.3.1"},
+		&Unknown{Op: "grfe", Data: []byte{1, 2, 3}},
+	}
+	for _, m := range messages {
+		var b bytes.Buffer
+		if err := WriteServerMessage(&b, m); err != nil {
+			t.Fatalf("%s: %v", m.Operation(), err)
+		}
+		got, err := ReadMessage(&b)
+		if err != nil {
+			t.Fatalf("%s: %v", m.Operation(), err)
+		}
+		if !reflect.DeepEqual(got, m) {
+			t.Errorf("%s: read %+v, want %+v", m.Operation(), got, m)
+		}
+		if b.Len() != 0 {
+			t.Errorf("%s: %d bytes left", m.Operation(), b.Len())
+		}
+	}
+}
+
+func TestAudgWithoutSequenceNumber(t *testing.T) {
+	m := &Audg{New_left: 1, SequenceNumber: 9}
+	b, _ := m.MarshalBinary()
+	if err := m.UnmarshalBinary(b[:audgLen]); err != nil {
+		t.Fatal(err)
+	}
+	if m.New_left != 1 || m.SequenceNumber != 0 {
+		t.Errorf("read %+v", m)
+	}
+}
+
+func TestShortMessage(t *testing.T) {
+	tests := []struct {
+		m   Message
+		min int // size of the fixed part
+	}{
+		{new(HELO), heloLen},
+		{new(STAT), statLen},
+		{new(BYE), 1},
+		{new(DSCO), 1},
+		{new(SETD), 1},
+		{new(Strm), strmLen},
+		{new(Cont), 5},
+		{new(Audg), audgLen},
+		{new(Aude), 2},
+		{new(Setd), 1},
+		{new(Serv), 4},
+	}
+	for _, tt := range tests {
+		if err := tt.m.UnmarshalBinary(make([]byte, tt.min-1)); !errors.Is(err, ErrShortMessage) {
+			t.Errorf("%s of %d bytes: error %v, want ErrShortMessage", tt.m.Operation(), tt.min-1, err)
+		}
+		if err := tt.m.UnmarshalBinary(make([]byte, tt.min)); err != nil {
+			t.Errorf("%s of %d bytes: %v", tt.m.Operation(), tt.min, err)
+		}
+	}
+
+	// Through the frame readers
+	if _, err := ReadMessage(bytes.NewReader([]byte{0, 5, 's', 't', 'r', 'm', 'q'})); err == nil {
+		t.Error("short strm frame read without error")
+	}
+	if _, err := ReadClientMessage(bytes.NewReader([]byte{'B', 'Y', 'E', '!', 0, 0, 0, 0})); err == nil {
+		t.Error("short BYE! frame read without error")
+	}
+}
+
+func TestBadFrame(t *testing.T) {
+	if _, err := ReadMessage(bytes.NewReader([]byte{0, 3, 's', 't', 'a', 't'})); err == nil {
+		t.Error("frame with length 3 read without error")
+	}
+	if _, err := ReadMessage(bytes.NewReader([]byte{0, 10, 'v', 'e', 'r', 's', '8'})); err != io.ErrUnexpectedEOF {
+		t.Errorf("truncated frame: error %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+	if _, err := ReadClientMessage(bytes.NewReader([]byte{'M', 'E', 'T', 'A', 0, 0, 1, 0, 'x'})); err != io.ErrUnexpectedEOF {
+		t.Errorf("truncated frame: error %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+	if err := WriteServerMessage(io.Discard, &Vers{Version: strings.Repeat("x", 0xffff)}); err == nil {
+		t.Error("frame too large written without error")
+	}
+}
+
+func TestHELOLongCapabilities(t *testing.T) {
+	caps := Capabilities{
+		Model:              "squeezeplay",
+		ModelName:          "slimgo",
+		Firmware:           "v1",
+		Codecs:             []string{"flc", "pcm", "mp3"},
+		MaxSampleRate:      192000,
+		AccuratePlayPoints: true,
+		HasDigitalOut:      true,
+		SyncgroupID:        "0123456789",
+		Flags:              []string{"CanHTTPS=1"},
+	}
+	want := "model=squeezeplay,modelName=slimgo,Firmware=v1,flc,pcm,mp3,MaxSampleRate=192000," +
+		"AccuratePlayPoints=1,HasDigitalOut=1,SyncgroupID=0123456789,CanHTTPS=1"
+	if s := caps.String(); s != want {
+		t.Errorf("capabilities %q, want %q", s, want)
+	}
+
+	// Longer than fits the 16 bits length of the server frames
+	for i := 0; i < 10000; i++ {
+		caps.Codecs = append(caps.Codecs, "codec"+strings.Repeat("x", i%10))
+	}
+	m := NewHELO([6]uint8{1, 2, 3, 4, 5, 6}, caps)
+	m.SetReconnect(12345)
+	var b bytes.Buffer
+	if err := WriteMessage(&b, m); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadClientMessage(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	helo := got.(*HELO)
+	if len(helo.Capabilities) <= 0xffff || helo.Capabilities != caps.String() {
+		t.Errorf("capabilities of %d bytes, want the %d bytes sent", len(helo.Capabilities), len(caps.String()))
+	}
+	if helo.WLanChannelList&WLanReconnect == 0 || helo.BytesReceived != 12345 {
+		t.Errorf("reconnect not set in %+v", helo)
+	}
+}
+
+// discoveryResponse returns a response with the tags and values in pairs
+func discoveryResponse(tags ...string) []byte {
+	b := []byte{'E'}
+	for i := 0; i < len(tags); i += 2 {
+		b = append(b, tags[i]...)
+		b = append(b, byte(len(tags[i+1])))
+		b = append(b, tags[i+1]...)
+	}
+	return b
+}
+
+func TestParseDiscoveryResponse(t *testing.T) {
+	from := net.IPv4(192, 168, 1, 2)
+	data := discoveryResponse("NAME", "lms", "JSON", "9000", "VERS", "8.3.1", "UUID", "abc", "IPAD", "192.168.1.10")
+	server, err := ParseDiscoveryResponse(data, from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Server{Addr: net.IPv4(192, 168, 1, 10), Port: Port, Name: "lms", JSONPort: 9000, Version: "8.3.1", UUID: "abc"}
+	if !reflect.DeepEqual(server, want) {
+		t.Errorf("parsed %+v, want %+v", server, want)
+	}
+
+	// Without IPAD the address it came from is used, unknown tags are skipped
+	server, err = ParseDiscoveryResponse(discoveryResponse("NAME", "lms", "XXXX", ""), from)
+	if err != nil || !server.Addr.Equal(from) || server.Name != "lms" {
+		t.Errorf("parsed %+v, %v", server, err)
+	}
+	if _, err = ParseDiscoveryResponse(discoveryResponse("NAME", "lms"), nil); err == nil {
+		t.Error("response without address parsed")
+	}
+	if _, err = ParseDiscoveryResponse([]byte("eIPAD\x00"), from); err == nil {
+		t.Error("request parsed as response")
+	}
+	if _, err = ParseDiscoveryResponse(nil, from); err == nil {
+		t.Error("empty response parsed")
+	}
+
+	// Cut within a tag, its length or its value
+	boundaries := map[int]bool{1: true}
+	for n, rest := 1, data[1:]; len(rest) > 0; {
+		size := 5 + int(rest[4])
+		n += size
+		rest = rest[size:]
+		boundaries[n] = true
+	}
+	for n := 1; n < len(data); n++ {
+		_, err := ParseDiscoveryResponse(data[:n], from)
+		if boundaries[n] && err != nil {
+			t.Errorf("response cut after a tag at %d: %v", n, err)
+		}
+		if !boundaries[n] && err == nil {
+			t.Errorf("response truncated at %d parsed", n)
+		}
+	}
+}
+
+func TestSelectServer(t *testing.T) {
+	servers := []Server{
+		{Addr: net.IPv4(10, 0, 0, 1), Name: "Kitchen", UUID: "aaa"},
+		{Addr: net.IPv4(10, 0, 0, 2), Name: "study", UUID: "bbb"},
+	}
+	for spec, want := range map[string]string{
+		"":             "Kitchen",
+		"name:STUDY":   "study",
+		"uuid:AAA":     "Kitchen",
+		"10.0.0.2":     "study",
+		"name:garage":  "",
+		"192.168.1.10": "",
+	} {
+		server, err := SelectServer(servers, spec)
+		if server.Name != want || (err == nil) != (want != "") {
+			t.Errorf("SelectServer(%q) = %q, %v, want %q", spec, server.Name, err, want)
+		}
+	}
+	if _, err := SelectServer(nil, ""); err == nil {
+		t.Error("server selected from none")
+	}
+}